@@ -3,6 +3,7 @@ package ssair
 import (
 	"bytes"
 	"fmt"
+	"go/types"
 	"math"
 	"strings"
 
@@ -51,11 +52,10 @@ func regnum(v *ssa.Value) int16 {
 	return ssaRegToReg[reg.(*ssa.Register).Num]
 }
 
-// autoVar returns a *Node and int64 representing the auto variable and offset within it
-// where v should be spilled.
-func autoVar(v *ssa.Value) (*Node, int64) {
+// autoVar returns the variable and offset within it where v should be spilled.
+func autoVar(v *ssa.Value) (ssaVar, int64) {
 	loc := v.Block.Func.RegAlloc[v.ID].(ssa.LocalSlot)
-	return loc.N.(*Node), loc.Off
+	return loc.N.(ssaVar), loc.Off
 }
 
 type LSym struct {
@@ -441,7 +441,8 @@ func Mconv(a *Addr) string {
 
 	case NAME_PARAM:
 		if a.Sym != nil {
-			str = fmt.Sprintf("%s%s(FP)", a.Sym.Name, offConv(a.Offset))
+			// go vet wants name+off(FP) even when off is 0
+			str = fmt.Sprintf("%s%+d(FP)", a.Sym.Name, a.Offset)
 		} else {
 			str = fmt.Sprintf("%s(FP)", offConv(a.Offset))
 		}
@@ -562,8 +563,14 @@ func Preamble() string {
 	return preamble
 }
 
-func FuncProto(name string, frameSize, argsSize int) string {
-	a := fmt.Sprintf("TEXT ·%v(SB),$%v-%v", name, frameSize, argsSize)
+// Frame describes the stack frame of a function.
+type Frame struct {
+	Size     int64 // size of the autos, address taken locals and spill slots
+	ArgsSize int64 // size of the parameters and results
+}
+
+func FuncProto(name string, frame Frame) string {
+	a := fmt.Sprintf("TEXT ·%v(SB),$%v-%v", name, frame.Size, frame.ArgsSize)
 	return a
 }
 
@@ -575,13 +582,17 @@ func Assemble(fn []*Prog) (assembly string) {
 	return assembly
 }
 
-func GenProg(f *ssa.Func) (fnProg []*Prog, ok bool) {
+func GenProg(f *ssa.Func) (fnProg []*Prog, frame Frame, ok bool) {
 
 	Pc := new(Prog)
 
 	var s genState
 
-	// e := f.Config.Frontend().(*ssaExport)
+	e := f.Config.Frontend().(*ssaExport)
+	frame.ArgsSize = ArgsSize(e.fnType)
+
+	// Allocate stack frame
+	frame.Size = allocauto(f)
 	// We're about to emit a bunch of Progs.
 	// Since the only way to get here is to explicitly request it,
 	// just fail on unimplemented instead of trying to unwind our mess.
@@ -666,9 +677,6 @@ func GenProg(f *ssa.Func) (fnProg []*Prog, ok bool) {
 		}*/
 	}
 
-	// Allocate stack frame
-	//allocauto(ptxt)
-
 	// Generate gc bitmaps.
 	/*liveness(Curfn, ptxt, gcargs, gclocals)
 	gcsymdup(gcargs)
@@ -682,7 +690,46 @@ func GenProg(f *ssa.Func) (fnProg []*Prog, ok bool) {
 
 	// Remove leftover instrumentation from the instruction stream.
 	//removevardef(ptxt)
-	return funcProgs, true
+	return funcProgs, frame, true
+}
+
+// allocauto assigns frame offsets to the autos of f, the address taken
+// locals and the spill slots picked by the register allocator, and returns
+// the frame size. Autos are below the pseudo-SP so their offsets are
+// negative.
+func allocauto(f *ssa.Func) int64 {
+	std := StdSizes()
+	var size int64
+	seen := map[ssaVar]bool{}
+	alloc := func(n ssaVar) {
+		if seen[n] || n.Class() != PAUTO {
+			return
+		}
+		seen[n] = true
+		t := n.Typ().(*Type)
+		size = align(size+t.Size(), t.Alignment())
+		switch n := n.(type) {
+		case *ssaLocal:
+			n.xoffset = -size
+		case *ssaAuto:
+			n.xoffset = -size
+		default:
+			Fatalf("unhandled auto %v", n)
+		}
+	}
+	for _, b := range f.Blocks {
+		for _, v := range b.Values {
+			if sym, ok := v.Aux.(*ssa.AutoSymbol); ok {
+				alloc(sym.Node.(ssaVar))
+			}
+			if int(v.ID) < len(f.RegAlloc) {
+				if loc, ok := f.RegAlloc[v.ID].(ssa.LocalSlot); ok {
+					alloc(loc.N.(ssaVar))
+				}
+			}
+		}
+	}
+	return align(size, std.MaxAlign)
 }
 
 // opregreg emits instructions for
@@ -1245,11 +1292,13 @@ func (s *genState) genValue(v *ssa.Value) []*Prog {
 		p.From.Node = n
 		//p.From.Sym = Linksym(n.Sym)
 		p.From.Offset = off
+		p.From.Offset += n.Xoffset()
 		if n.Class() == PPARAM {
 			p.From.Name = NAME_PARAM
-			p.From.Offset += n.Xoffset()
+			p.From.Sym = argSym(n, off)
 		} else {
 			p.From.Name = NAME_AUTO
+			p.From.Sym = &LSym{Name: n.Name()}
 		}
 		p.To.Type = TYPE_REG
 		p.To.Reg = regnum(v)
@@ -1268,11 +1317,13 @@ func (s *genState) genValue(v *ssa.Value) []*Prog {
 		p.To.Node = n
 		//p.To.Sym = Linksym(n.Sym)
 		p.To.Offset = off
+		p.To.Offset += n.Xoffset()
 		if n.Class() == PPARAM {
 			p.To.Name = NAME_PARAM
-			p.To.Offset += n.Xoffset()
+			p.To.Sym = argSym(n, off)
 		} else {
 			p.To.Name = NAME_AUTO
+			p.To.Sym = &LSym{Name: n.Name()}
 		}
 		progs = append(progs, p)
 	case ssa.OpPhi:
@@ -1311,7 +1362,14 @@ func (s *genState) genValue(v *ssa.Value) []*Prog {
 	case ssa.OpAMD64LoweredGetG:
 		panic("unimplementedf")
 	case ssa.OpAMD64CALLstatic:
-		panic("unimplementedf")
+		p = CreateProg(obj.ACALL)
+		p.To.Type = TYPE_MEM
+		p.To.Name = NAME_EXTERN
+		p.To.Sym = v.Aux.(*LSym)
+		if Maxarg < v.AuxInt {
+			Maxarg = v.AuxInt
+		}
+		progs = append(progs, p)
 	case ssa.OpAMD64CALLclosure:
 		panic("unimplementedf")
 	case ssa.OpAMD64CALLdefer:
@@ -1414,14 +1472,12 @@ func (s *genState) genValue(v *ssa.Value) []*Prog {
 			case ssa.OpAMD64MOVQload, ssa.OpAMD64MOVLload, ssa.OpAMD64MOVWload, ssa.OpAMD64MOVBload,
 				ssa.OpAMD64MOVQstore, ssa.OpAMD64MOVLstore, ssa.OpAMD64MOVWstore, ssa.OpAMD64MOVBstore:
 				if w.Args[0] == v.Args[0] && w.Aux == nil && w.AuxInt >= 0 && w.AuxInt < minZeroPage {
-					panic("unimplementedf")
-					//return
+					return progs
 				}
 			case ssa.OpAMD64MOVQstoreconst, ssa.OpAMD64MOVLstoreconst, ssa.OpAMD64MOVWstoreconst, ssa.OpAMD64MOVBstoreconst:
 				off := ssa.ValAndOff(v.AuxInt).Off()
 				if w.Args[0] == v.Args[0] && w.Aux == nil && off >= 0 && off < minZeroPage {
-					panic("unimplementedf")
-					//return
+					return progs
 				}
 			}
 			if w.Type.IsMemory() {
@@ -1672,19 +1728,78 @@ func addAux2(a *Addr, v *ssa.Value, offset int64) {
 		n := sym.Node.(ssaVar)
 		a.Name = NAME_PARAM
 		a.Node = n
-		a.Sym = &LSym{} //Linksym(n.Orig.Sym)
-		a.Sym.Name = n.Name()
-		a.Offset += n.Xoffset() // TODO: why do I have to add this here?  I don't for auto variables.
+		a.Sym = argSym(n, a.Offset)
+		a.Offset += n.Xoffset()
 	case *ssa.AutoSymbol:
 		n := sym.Node.(ssaVar)
 		a.Name = NAME_AUTO
 		a.Node = n
-		//a.Sym = Linksym(n.Sym)
+		a.Sym = &LSym{Name: n.Name()}
+		a.Offset += n.Xoffset()
 	default:
 		v.Fatalf("aux in %s not implemented %#v", v, v.Aux)
 	}
 }
 
+// argSym returns the symbol for the word at offset off of the argument n.
+// The names are the ones go vet's asmdecl check expects, a part of a
+// multi-word argument is named by suffixes, e.g. s_len for the length of
+// the string s.
+func argSym(n ssaVar, off int64) *LSym {
+	return &LSym{Name: n.Name() + componentName(n.Typ().(*Type), off)}
+}
+
+// componentName returns the suffix naming the part of a value of type t
+// at offset off.
+func componentName(t *Type, off int64) string {
+	ptrSize := StdSizes().WordSize
+	switch {
+	case t.IsVector():
+		// vectors are moved whole
+		return ""
+	case t.IsString():
+		if off < ptrSize {
+			return "_base"
+		}
+		return "_len"
+	case t.IsSlice():
+		switch off / ptrSize {
+		case 0:
+			return "_base"
+		case 1:
+			return "_len"
+		default:
+			return "_cap"
+		}
+	case t.IsInterface():
+		if off >= ptrSize {
+			return "_data"
+		}
+		if t.Underlying().(*types.Interface).Empty() {
+			return "_type"
+		}
+		return "_itab"
+	case t.IsComplex():
+		if off < t.Size()/2 {
+			return "_real"
+		}
+		return "_imag"
+	case t.IsStruct():
+		for i := t.NumFields() - 1; i >= 0; i-- {
+			if foff := t.FieldOff(i); foff <= off {
+				ft := t.FieldType(i).(*Type)
+				return "_" + t.FieldName(i) + componentName(ft, off-foff)
+			}
+		}
+	case t.IsArray():
+		elem := t.Elem().(*Type)
+		if size := elem.Size(); size > 0 {
+			return fmt.Sprintf("_%d", off/size) + componentName(elem, off%size)
+		}
+	}
+	return ""
+}
+
 // ssaRegToReg maps ssa register numbers to obj register numbers.
 var ssaRegToReg = [...]int16{
 	x86.REG_AX,
@@ -1761,6 +1876,9 @@ func regMoveByTypeAMD64(t ssa.Type) int {
 			return x86.AMOVL
 		case 8:
 			return x86.AMOVQ
+		case 16:
+			// SSE vectors, ssa.TypeInt128 values are in the X registers
+			return x86.AMOVOU
 		default:
 			panic("bad int register width")
 		}
@@ -7,16 +7,22 @@ import (
 	"go/parser"
 	"go/token"
 	"go/types"
+	"strings"
 
 	"github.com/bjwbell/cmd/obj"
 	"github.com/bjwbell/ssa"
 )
 
 func TypeCheckFn(file, pkgName, fn string, log bool) (fileTok *token.File, fileAst *ast.File, fnDecl *ast.FuncDecl, function *types.Func, info *types.Info, er error) {
+	return typeCheckFn(file, pkgName, fn, importer.Default(), log)
+}
+
+// typeCheckFn is TypeCheckFn with the packages file imports loaded by imp.
+func typeCheckFn(file, pkgName, fn string, imp types.Importer, log bool) (fileTok *token.File, fileAst *ast.File, fnDecl *ast.FuncDecl, function *types.Func, info *types.Info, er error) {
 	var conf types.Config
-	conf.Importer = importer.Default()
+	conf.Importer = imp
 	fset := token.NewFileSet()
-	fileAst, err := parser.ParseFile(fset, file, nil, parser.AllErrors)
+	fileAst, err := parser.ParseFile(fset, file, nil, parser.AllErrors|parser.ParseComments)
 	var terrors string
 	if err != nil {
 		fmt.Printf("Error parsing %v, error message: %v\n", file, err)
@@ -30,6 +36,8 @@ func TypeCheckFn(file, pkgName, fn string, log bool) (fileTok *token.File, fileA
 		Types: make(map[ast.Expr]types.TypeAndValue),
 		Defs:  make(map[*ast.Ident]types.Object),
 		Uses:  make(map[*ast.Ident]types.Object),
+
+		Selections: make(map[*ast.SelectorExpr]*types.Selection),
 	}
 	pkg, err := conf.Check(pkgName, fset, files, info)
 	if err != nil {
@@ -42,21 +50,23 @@ func TypeCheckFn(file, pkgName, fn string, log bool) (fileTok *token.File, fileA
 	fmt.Println("pkg: ", pkg)
 	fmt.Println("pkg.Complete:", pkg.Complete())
 	scope := pkg.Scope()
-	obj := scope.Lookup(fn)
-	if obj == nil {
-		fmt.Println("Couldnt lookup function: ", fn)
-		er = fmt.Errorf("Couldnt lookup function: %v", fn)
-		return
-	}
-	function, ok := obj.(*types.Func)
-	if !ok {
-		fmt.Printf("%v is a %v, not a function\n", fn, obj.Type().String())
-		er = fmt.Errorf("%v is a %v, not a function\n", fn, obj.Type().String())
-		return
+	recv, ptrRecv, name := splitMethodName(fn)
+	if recv == "" {
+		obj := scope.Lookup(fn)
+		if obj == nil {
+			fmt.Println("Couldnt lookup function: ", fn)
+			er = fmt.Errorf("Couldnt lookup function: %v", fn)
+			return
+		}
+		if _, ok := obj.(*types.Func); !ok {
+			fmt.Printf("%v is a %v, not a function\n", fn, obj.Type().String())
+			er = fmt.Errorf("%v is a %v, not a function\n", fn, obj.Type().String())
+			return
+		}
 	}
 	for _, decl := range fileAst.Decls {
 		if fdecl, ok := decl.(*ast.FuncDecl); ok {
-			if fdecl.Name.Name == fn {
+			if fdecl.Name.Name == name && matchRecv(fdecl, recv, ptrRecv) {
 				fnDecl = fdecl
 				break
 			}
@@ -67,9 +77,53 @@ func TypeCheckFn(file, pkgName, fn string, log bool) (fileTok *token.File, fileA
 		er = fmt.Errorf("couldn't find function: %v", fn)
 		return
 	}
+	function = info.Defs[fnDecl.Name].(*types.Func)
 	return
 }
 
+// splitMethodName splits the method name fn, of the form "T.M" or
+// "(*T).M", into the receiver type name, whether the receiver is a
+// pointer and the method name. The receiver is "" if fn is a function.
+func splitMethodName(fn string) (recv string, ptrRecv bool, name string) {
+	i := strings.LastIndex(fn, ".")
+	if i < 0 {
+		return "", false, fn
+	}
+	recv, name = fn[:i], fn[i+1:]
+	if strings.HasPrefix(recv, "(*") && strings.HasSuffix(recv, ")") {
+		return recv[2 : len(recv)-1], true, name
+	}
+	return recv, false, name
+}
+
+// matchRecv reports whether the receiver of fdecl is recv, or *recv if
+// ptrRecv is set. A recv of "" only matches functions.
+func matchRecv(fdecl *ast.FuncDecl, recv string, ptrRecv bool) bool {
+	if fdecl.Recv == nil || len(fdecl.Recv.List) == 0 {
+		return recv == ""
+	}
+	typ := fdecl.Recv.List[0].Type
+	star, isPtr := typ.(*ast.StarExpr)
+	if isPtr {
+		typ = star.X
+	}
+	ident, ok := typ.(*ast.Ident)
+	return ok && ident.Name == recv && isPtr == ptrRecv
+}
+
+// FuncName returns the name of the function fn, as it appears in
+// assembly, without the package. Methods are named "T·M" or "(*T).M".
+func FuncName(fn *types.Func) string {
+	recv := fn.Type().(*types.Signature).Recv()
+	if recv == nil {
+		return fn.Name()
+	}
+	if ptr, ok := recv.Type().(*types.Pointer); ok {
+		return "(*" + ptr.Elem().(*types.Named).Obj().Name() + ")." + fn.Name()
+	}
+	return recv.Type().(*types.Named).Obj().Name() + "·" + fn.Name()
+}
+
 // BuildSSA parses the function, fn, which must be in ssa form and returns
 // the corresponding ssa.Func
 func BuildSSA(file, pkgName, fn string, log bool) (ssafn *ssa.Func, usessa bool) {
@@ -83,13 +137,14 @@ func BuildSSA(file, pkgName, fn string, log bool) (ssafn *ssa.Func, usessa bool)
 
 func getParameters(ctx Ctx, fn *types.Func) []*ssaParam {
 	signature := fn.Type().(*types.Signature)
-	if signature.Recv() != nil {
-		panic("methods unsupported (only functions are supported)")
-	}
 	var params []*ssaParam
+	if recv := signature.Recv(); recv != nil {
+		// the receiver is the first parameter
+		params = append(params, &ssaParam{v: recv, ctx: ctx, sig: signature})
+	}
 	for i := 0; i < signature.Params().Len(); i++ {
 		param := signature.Params().At(i)
-		n := ssaParam{v: param, ctx: ctx}
+		n := ssaParam{v: param, ctx: ctx, idx: len(params), sig: signature}
 		params = append(params, &n)
 	}
 	return params
@@ -97,13 +152,10 @@ func getParameters(ctx Ctx, fn *types.Func) []*ssaParam {
 
 func getReturnVar(ctx Ctx, fn *types.Func) []*ssaRetVar {
 	signature := fn.Type().(*types.Signature)
-	if signature.Recv() != nil {
-		panic("methods unsupported (only functions are supported)")
-	}
 	var results []*ssaRetVar
 	for i := 0; i < signature.Results().Len(); i++ {
 		ret := signature.Results().At(i)
-		n := ssaRetVar{v: ret, ctx: ctx}
+		n := ssaRetVar{v: ret, ctx: ctx, idx: i, sig: signature}
 		results = append(results, &n)
 	}
 	return results
@@ -123,6 +175,16 @@ func isParam(ctx Ctx, fn *types.Func, obj types.Object) bool {
 	return false
 }
 
+func isResult(ctx Ctx, fn *types.Func, obj types.Object) bool {
+	results := getReturnVar(ctx, fn)
+	for _, r := range results {
+		if r.v.Id() == obj.Id() {
+			return true
+		}
+	}
+	return false
+}
+
 func getLocalDecls(ctx Ctx, fnDecl *ast.FuncDecl, fn *types.Func) []*ssaLocal {
 	scope := fn.Scope()
 	names := scope.Names()
@@ -130,7 +192,12 @@ func getLocalDecls(ctx Ctx, fnDecl *ast.FuncDecl, fn *types.Func) []*ssaLocal {
 	for i := 0; i < len(names); i++ {
 		name := names[i]
 		obj := scope.Lookup(name)
-		if isParam(ctx, fn, obj) {
+		if _, ok := obj.(*types.Var); !ok {
+			// constants and types aren't variables
+			continue
+		}
+		if isParam(ctx, fn, obj) || isResult(ctx, fn, obj) {
+			// named results are in the function scope too
 			continue
 		}
 		node := ssaLocal{obj: obj, ctx: ctx}
@@ -180,34 +247,41 @@ func getVars(ctx Ctx, fnDecl *ast.FuncDecl, fnType *types.Func) []ssaVar {
 }
 
 func buildSSA(ftok *token.File, f *ast.File, fn *ast.FuncDecl, fnType *types.Func, fnInfo *types.Info, log bool) (ssafn *ssa.Func, ok bool) {
+	ssafn = buildFunc(ftok, fn, fnType, fnInfo, log)
+
+	fmt.Println("f:", f)
+
+	ssa.Compile(ssafn)
+
+	return ssafn, true
+}
+
+// buildFunc converts fn, which must be in ssa form, to an ssa.Func
+// without compiling it.
+func buildFunc(ftok *token.File, fn *ast.FuncDecl, fnType *types.Func, fnInfo *types.Info, log bool) *ssa.Func {
 
 	// HACK, hardcoded
 	arch := "amd64"
 
-	signature, ok := fnType.Type().(*types.Signature)
-	if !ok {
+	if _, ok := fnType.Type().(*types.Signature); !ok {
 		panic("function type is not types.Signature")
 	}
-	if signature.Recv() != nil {
-		fmt.Println("Methods not supported")
-		return nil, false
-	}
-	if signature.Results().Len() > 1 {
-		fmt.Println("Multiple return values not supported")
-	}
 
 	var e ssaExport
 	var s state
 	e.log = log
+	e.fnType = fnType
 	link := obj.Link{}
-	s.ctx = Ctx{ftok, fnInfo}
+	s.ctx = Ctx{ftok, fnInfo, map[types.Object]types.Type{}}
 	s.fnDecl = fn
 	s.fnType = fnType
 	s.fnInfo = fnInfo
 	s.config = ssa.NewConfig(arch, &e, &link, false)
 	s.f = s.config.NewFunc()
-	s.f.Name = fnType.Name()
+	s.f.Name = FuncName(fnType)
 	//s.f.Entry = s.f.NewBlock(ssa.BlockPlain)
+	s.pragma = parsePragmas(fn.Doc)
+	s.opValueTypes(fn.Body)
 
 	s.scanBlocks(fn.Body)
 	if len(s.blocks) < 1 {
@@ -228,12 +302,17 @@ func buildSSA(ftok *token.File, f *ast.File, fn *ast.FuncDecl, fnType *types.Fun
 	s.vars = map[ssaVar]*ssa.Value{}
 	s.vars[&memVar] = s.startmem
 
-	//s.varsyms = map[*Node]interface{}{}
+	s.varsyms = map[ssaVar]interface{}{}
+	s.addrtaken = addrTaken(fnInfo, fn.Body)
 
 	// Generate addresses of local declarations
 	s.decladdrs = map[ssaVar]*ssa.Value{}
-	vars := getVars(s.ctx, fn, fnType)
-	for _, v := range vars {
+	s.fnVars = getVars(s.ctx, fn, fnType)
+	for _, ret := range s.retVars() {
+		// named results start out as the zero value
+		s.vars[ret] = s.zeroVal(ret.Typ().(*Type))
+	}
+	for _, v := range s.fnVars {
 		switch v.Class() {
 		case PPARAM:
 			// aux := s.lookupSymbol(n, &ssa.ArgSymbol{Typ: n.Type, Node: n})
@@ -270,9 +349,64 @@ func buildSSA(ftok *token.File, f *ast.File, fn *ast.FuncDecl, fnType *types.Fun
 	// Link up variable uses to variable definitions
 	s.linkForwardReferences()
 
-	fmt.Println("f:", f)
+	return s.f
+}
 
-	ssa.Compile(s.f)
+// addrTaken returns the variables in body whose address is taken.
+func addrTaken(info *types.Info, body *ast.BlockStmt) map[types.Object]bool {
+	addrtaken := map[types.Object]bool{}
+	ast.Inspect(body, func(n ast.Node) bool {
+		if expr, ok := n.(*ast.UnaryExpr); ok && expr.Op == token.AND {
+			x := expr.X
+			for {
+				paren, ok := x.(*ast.ParenExpr)
+				if !ok {
+					break
+				}
+				x = paren.X
+			}
+			if ident, ok := x.(*ast.Ident); ok {
+				addrtaken[info.ObjectOf(ident)] = true
+			}
+		}
+		return true
+	})
+	return addrtaken
+}
 
-	return s.f, true
+// pragma is a set of flags from "//ssair:name" comments in the doc
+// comment of the function.
+type pragma int
+
+const (
+	noNilCheck  pragma = 1 << iota // ssair:nonilcheck, pointer dereferences aren't nil checked
+	noBounds                       // ssair:nobounds, indexing isn't bounds checked
+	fallThrough                    // ssair:fallthrough, blocks without a goto, if or return fall through to the next block
+)
+
+var pragmaNames = map[string]pragma{
+	"nonilcheck":  noNilCheck,
+	"nobounds":    noBounds,
+	"fallthrough": fallThrough,
+}
+
+// parsePragmas returns the pragmas in the doc comment, doc.
+func parsePragmas(doc *ast.CommentGroup) pragma {
+	var flags pragma
+	if doc == nil {
+		return flags
+	}
+	for _, c := range doc.List {
+		if !strings.HasPrefix(c.Text, "//ssair:") {
+			continue
+		}
+		name := strings.TrimSpace(strings.TrimPrefix(c.Text, "//ssair:"))
+		flag, ok := pragmaNames[name]
+		if !ok {
+			fmt.Printf("Warning: unknown pragma %v\n", c.Text)
+			continue
+		}
+		flags |= flag
+	}
+	return flags
 }
@@ -59,10 +59,10 @@ func main() {
 	}
 	_, protoImports, protoFn := ssair.GoProto(fnType)
 
-	if fnProg, ok := ssair.GenProg(ssafn); ok {
+	if fnProg, frame, ok := ssair.GenProg(ssafn); ok {
 		preamble := ssair.Preamble()
 		assembly := ssair.Assemble(fnProg)
-		fnProto := ssair.FuncProto(ssafn.Name, 0, 0)
+		fnProto := ssair.FuncProto(ssafn.Name, frame)
 		fmt.Println("assembly:")
 		fmt.Println(fnProto)
 		fmt.Println(assembly)
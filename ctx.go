@@ -8,4 +8,15 @@ import (
 type Ctx struct {
 	file *token.File
 	fn   *types.Info
+	// opTypes are the types of the OpValue variables, see opValueTypes
+	opTypes map[types.Object]types.Type
+}
+
+// varType returns the type of the variable obj, for an OpValue variable
+// it's the type of the values assigned to it.
+func (ctx Ctx) varType(obj types.Object) types.Type {
+	if t, ok := ctx.opTypes[obj]; ok {
+		return t
+	}
+	return obj.Type()
 }
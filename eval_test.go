@@ -0,0 +1,932 @@
+package ssair
+
+import (
+	"fmt"
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/bjwbell/ssa"
+)
+
+// buildTestFn type checks src, the source of package p, and converts its
+// function fn to ssa without compiling it. It also returns the signature
+// of fn.
+func buildTestFn(t *testing.T, src, fn string) (*ssa.Func, *types.Signature) {
+	dir, err := ioutil.TempDir("", "ssair")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "p.go")
+	if err := ioutil.WriteFile(file, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ftok, _, fnDecl, fnType, info, err := typeCheckFn(file, "p", fn, testImporter{}, false)
+	if err != nil {
+		t.Fatalf("type checking %v: %v", fn, err)
+	}
+	return buildFunc(ftok, fnDecl, fnType, info, false), fnType.Type().(*types.Signature)
+}
+
+// testImporter imports the ssa package and this package from the
+// declarations test sources use, and other packages with the default
+// importer.
+type testImporter map[string]*types.Package
+
+func (imp testImporter) Import(path string) (*types.Package, error) {
+	if pkg, ok := imp[path]; ok {
+		return pkg, nil
+	}
+	fset := token.NewFileSet()
+	var files []*ast.File
+	switch path {
+	case "github.com/bjwbell/ssa":
+		// the ops test sources use, with the values of this build
+		src := "package ssa\n\ntype Op int32\n\n"
+		ops := []ssa.Op{ssa.OpAdd64}
+		for op := range intrinsics {
+			ops = append(ops, op)
+		}
+		for _, op := range ops {
+			src += fmt.Sprintf("const Op%v Op = %d\n", op, int32(op))
+		}
+		f, err := parser.ParseFile(fset, "ssa.go", src, 0)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	case pkgPath:
+		// the intrinsics and vector types from op.go, and Phi
+		f, err := parser.ParseFile(fset, "op.go", nil, 0)
+		if err != nil {
+			return nil, err
+		}
+		phi, err := parser.ParseFile(fset, "phi.go", "package ssair\n\nfunc Phi(vars map[string]int) int\n", 0)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f, phi)
+	default:
+		return importer.Default().Import(path)
+	}
+	conf := types.Config{Importer: imp}
+	pkg, err := conf.Check(path, fset, files, nil)
+	if err != nil {
+		return nil, err
+	}
+	imp[path] = pkg
+	return pkg, nil
+}
+
+// buildTestFunc is buildTestFn without the signature.
+func buildTestFunc(t *testing.T, src, fn string) *ssa.Func {
+	f, _ := buildTestFn(t, src, fn)
+	return f
+}
+
+// buildError returns the error converting the function fn of src to ssa,
+// or "" if there's none.
+func buildError(t *testing.T, src, fn string) (msg string) {
+	defer func() {
+		if r := recover(); r != nil {
+			msg = fmt.Sprint(r)
+		}
+	}()
+	buildTestFunc(t, src, fn)
+	return ""
+}
+
+// evalTest is a call of the function fn with the arguments args, it
+// returns the results want, or panics calling the runtime function
+// panic ("nil" for a nil dereference).
+type evalTest struct {
+	fn    string
+	args  []interface{}
+	want  []interface{}
+	panic string
+}
+
+// checkEval builds the functions of src and checks the results of the
+// calls of tests, evaluated by an evaluator.
+func checkEval(t *testing.T, src string, tests []evalTest) {
+	type built struct {
+		f   *ssa.Func
+		sig *types.Signature
+	}
+	fns := map[string]built{}
+	for _, test := range tests {
+		fn, ok := fns[test.fn]
+		if !ok {
+			fn.f, fn.sig = buildTestFn(t, src, test.fn)
+			fns[test.fn] = fn
+		}
+		params := sigParams(fn.sig)
+		if len(test.args) != len(params) {
+			t.Fatalf("%v: got %v args, want %v", test.fn, len(test.args), len(params))
+		}
+		var args []interface{}
+		for i, p := range params {
+			args = append(args, fromGo(p, reflect.ValueOf(test.args[i])))
+		}
+		call := fmt.Sprintf("%v%v", test.fn, test.args)
+		results, panicked := eval(t, fn.f, fn.sig, args)
+		if panicked != test.panic {
+			t.Errorf("%v: got panic %q, want %q", call, panicked, test.panic)
+			continue
+		}
+		if panicked != "" {
+			continue
+		}
+		for i, r := range results {
+			rt := &Type{fn.sig.Results().At(i).Type()}
+			got := plain(rt, r)
+			want := plain(rt, fromGo(rt, reflect.ValueOf(test.want[i])))
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("%v: got result %v = %v, want %v", call, i, got, want)
+			}
+		}
+	}
+}
+
+// sigParams returns the types of the parameters of sig, the receiver is
+// first.
+func sigParams(sig *types.Signature) []*Type {
+	var params []*Type
+	if sig.Recv() != nil {
+		params = append(params, &Type{sig.Recv().Type()})
+	}
+	for i := 0; i < sig.Params().Len(); i++ {
+		params = append(params, &Type{sig.Params().At(i).Type()})
+	}
+	return params
+}
+
+// The evaluator represents integers and bools as uint64s, with the bits
+// above the size of the type zero, floats as float64s, rounded to float32
+// for float32 values, complex values as complex128s, pointers as ptrs,
+// strings and slices as sliceVals and structs and arrays as
+// []interface{}. Memory is made of objects, the values stored in an
+// object are split into scalars kept at their offsets.
+
+// object is a variable in memory.
+type object struct {
+	vals map[int64]interface{}
+}
+
+// ptr is a pointer to off bytes into obj, obj is nil for nil pointers.
+type ptr struct {
+	obj *object
+	off int64
+}
+
+// sliceVal is a string or a slice.
+type sliceVal struct {
+	ptr      ptr
+	len, cap uint64
+}
+
+// iface is an interface value, its first word is the itab, or the type
+// for an empty interface.
+type iface struct {
+	itab, data ptr
+}
+
+// vector is an SSE vector.
+type vector [16]byte
+
+// evalPanic is the panic of the evaluated function.
+type evalPanic string
+
+// zero returns the zero value of type t.
+func zero(t *Type) interface{} {
+	switch {
+	case t.IsVector():
+		return vector{}
+	case t.IsBoolean(), t.IsInteger():
+		return uint64(0)
+	case t.IsFloat():
+		return float64(0)
+	case t.IsComplex():
+		return complex128(0)
+	case t.IsString(), t.IsSlice():
+		return sliceVal{}
+	case t.IsInterface():
+		return iface{}
+	case t.IsStruct():
+		var fields []interface{}
+		for i := 0; i < t.NumFields(); i++ {
+			fields = append(fields, zero(t.FieldType(i).(*Type)))
+		}
+		return fields
+	case t.IsArray():
+		var elems []interface{}
+		for i := int64(0); i < t.NumElem(); i++ {
+			elems = append(elems, zero(t.Elem().(*Type)))
+		}
+		return elems
+	}
+	return ptr{}
+}
+
+// load returns the value of type t at p.
+func load(t *Type, p ptr) interface{} {
+	if p.obj == nil {
+		panic(evalPanic("nil"))
+	}
+	switch {
+	case t.IsStruct():
+		var fields []interface{}
+		for i := 0; i < t.NumFields(); i++ {
+			fields = append(fields, load(t.FieldType(i).(*Type), ptr{p.obj, p.off + t.FieldOff(i)}))
+		}
+		return fields
+	case t.IsArray():
+		elem := t.Elem().(*Type)
+		var elems []interface{}
+		for i := int64(0); i < t.NumElem(); i++ {
+			elems = append(elems, load(elem, ptr{p.obj, p.off + i*elem.Size()}))
+		}
+		return elems
+	}
+	if v, ok := p.obj.vals[p.off]; ok {
+		return v
+	}
+	return zero(t)
+}
+
+// store stores v, of type t, at p.
+func store(t *Type, p ptr, v interface{}) {
+	if p.obj == nil {
+		panic(evalPanic("nil"))
+	}
+	switch {
+	case t.IsStruct():
+		for i, f := range v.([]interface{}) {
+			store(t.FieldType(i).(*Type), ptr{p.obj, p.off + t.FieldOff(i)}, f)
+		}
+	case t.IsArray():
+		elem := t.Elem().(*Type)
+		for i, e := range v.([]interface{}) {
+			store(elem, ptr{p.obj, p.off + int64(i)*elem.Size()}, e)
+		}
+	default:
+		p.obj.vals[p.off] = v
+	}
+}
+
+// newObject returns a pointer to a new object holding v, of type t.
+func newObject(t *Type, v interface{}) ptr {
+	p := ptr{&object{map[int64]interface{}{}}, 0}
+	store(t, p, v)
+	return p
+}
+
+// newArray returns a pointer to a new object holding the elements elems,
+// of type t.
+func newArray(t *Type, elems []interface{}) ptr {
+	p := ptr{&object{map[int64]interface{}{}}, 0}
+	for i, e := range elems {
+		store(t, ptr{p.obj, int64(i) * t.Size()}, e)
+	}
+	return p
+}
+
+// fromGo converts the Go value x to a value of type t.
+func fromGo(t *Type, x reflect.Value) interface{} {
+	switch {
+	case t.IsBoolean():
+		if x.Bool() {
+			return uint64(1)
+		}
+		return uint64(0)
+	case t.IsInteger():
+		switch x.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return mask(uint64(x.Int()), t.Size())
+		}
+		return mask(x.Uint(), t.Size())
+	case t.IsFloat():
+		if t.Size() == 4 {
+			return float64(float32(x.Float()))
+		}
+		return x.Float()
+	case t.IsComplex():
+		return x.Complex()
+	case t.IsString():
+		var b []interface{}
+		for _, c := range []byte(x.String()) {
+			b = append(b, uint64(c))
+		}
+		return sliceVal{newArray(Typ[types.Uint8], b), uint64(len(b)), uint64(len(b))}
+	case t.IsSlice():
+		if x.IsNil() {
+			return sliceVal{}
+		}
+		elem := t.Elem().(*Type)
+		var elems []interface{}
+		for i := 0; i < x.Cap(); i++ {
+			elems = append(elems, fromGo(elem, x.Slice(0, x.Cap()).Index(i)))
+		}
+		return sliceVal{newArray(elem, elems), uint64(x.Len()), uint64(x.Cap())}
+	case t.IsStruct():
+		var fields []interface{}
+		for i := 0; i < t.NumFields(); i++ {
+			fields = append(fields, fromGo(t.FieldType(i).(*Type), x.Field(i)))
+		}
+		return fields
+	case t.IsArray():
+		var elems []interface{}
+		for i := 0; i < x.Len(); i++ {
+			elems = append(elems, fromGo(t.Elem().(*Type), x.Index(i)))
+		}
+		return elems
+	case t.IsPtr():
+		if !x.IsValid() || x.IsNil() {
+			return ptr{}
+		}
+		elem := t.Elem().(*Type)
+		return newObject(elem, fromGo(elem, x.Elem()))
+	case t.IsInterface():
+		if !x.IsValid() || x.IsNil() {
+			return iface{}
+		}
+	case t.IsVector():
+		var v vector
+		for i := range v {
+			v[i] = byte(x.Index(i).Uint())
+		}
+		return v
+	}
+	panic(fmt.Sprintf("can't convert %v to %v", x, t))
+}
+
+// plain returns v, of type t, as a Go value that can be compared and
+// printed: ints, uints, floats, complex numbers, bools, strings, and
+// []interface{} for slices, structs and arrays.
+func plain(t *Type, v interface{}) interface{} {
+	switch {
+	case t.IsBoolean():
+		return v.(uint64) != 0
+	case t.IsInteger() && t.IsSigned():
+		return sext(v.(uint64), t.Size())
+	case t.IsString():
+		s := v.(sliceVal)
+		var b []byte
+		for i := uint64(0); i < s.len; i++ {
+			b = append(b, byte(load(Typ[types.Uint8], ptr{s.ptr.obj, s.ptr.off + int64(i)}).(uint64)))
+		}
+		return string(b)
+	case t.IsSlice():
+		s := v.(sliceVal)
+		elem := t.Elem().(*Type)
+		elems := []interface{}{}
+		for i := uint64(0); i < s.len; i++ {
+			elems = append(elems, plain(elem, load(elem, ptr{s.ptr.obj, s.ptr.off + int64(i)*elem.Size()})))
+		}
+		return elems
+	case t.IsStruct():
+		var fields []interface{}
+		for i, f := range v.([]interface{}) {
+			fields = append(fields, plain(t.FieldType(i).(*Type), f))
+		}
+		return fields
+	case t.IsArray():
+		var elems []interface{}
+		for _, e := range v.([]interface{}) {
+			elems = append(elems, plain(t.Elem().(*Type), e))
+		}
+		return elems
+	}
+	return v
+}
+
+// mask returns the low size bytes of x.
+func mask(x uint64, size int64) uint64 {
+	if size >= 8 {
+		return x
+	}
+	return x & (1<<uint(size*8) - 1)
+}
+
+// sext returns the low size bytes of x, sign extended.
+func sext(x uint64, size int64) int64 {
+	n := uint(64 - size*8)
+	return int64(x<<n) >> n
+}
+
+// evaluator evaluates a function built by buildFunc.
+type evaluator struct {
+	t       *testing.T
+	f       *ssa.Func
+	args    []interface{}
+	vals    map[*ssa.Value]interface{}
+	visit   map[*ssa.Value]int // the visit of the block the value is from
+	n       int                // the number of blocks visited
+	cur     *ssa.Block
+	objects map[ssa.GCNode]*object
+}
+
+// maxSteps is the number of blocks evaluated before giving up on a call,
+// it's likely an infinite loop.
+const maxSteps = 10000
+
+// eval evaluates the call of f, with the signature sig, with the args and
+// returns the results, or the runtime function it panics calling.
+func eval(t *testing.T, f *ssa.Func, sig *types.Signature, args []interface{}) (results []interface{}, panicked string) {
+	e := &evaluator{
+		t:       t,
+		f:       f,
+		args:    args,
+		vals:    map[*ssa.Value]interface{}{},
+		visit:   map[*ssa.Value]int{},
+		objects: map[ssa.GCNode]*object{},
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			p, ok := r.(evalPanic)
+			if !ok {
+				panic(r)
+			}
+			panicked = string(p)
+		}
+	}()
+	b, pred := f.Entry, -1
+	for ; e.n < maxSteps; e.n++ {
+		e.cur = b
+		// the phis take their values from the block before
+		phis := map[*ssa.Value]interface{}{}
+		for _, v := range b.Values {
+			if v.Op == ssa.OpPhi {
+				if pred < 0 {
+					t.Fatalf("%v: phi %v in the entry block", f.Name, v.LongString())
+				}
+				phis[v] = e.vals[v.Args[pred]]
+			}
+		}
+		for v, x := range phis {
+			e.vals[v] = x
+			e.visit[v] = e.n
+		}
+		for _, v := range b.Values {
+			e.value(v)
+		}
+		var next ssa.Edge
+		switch b.Kind {
+		case ssa.BlockPlain, ssa.BlockCheck:
+			next = b.Succs[0]
+		case ssa.BlockIf:
+			next = b.Succs[1]
+			if e.value(b.Control).(uint64) != 0 {
+				next = b.Succs[0]
+			}
+		case ssa.BlockRet:
+			for i := 0; i < sig.Results().Len(); i++ {
+				rt := &Type{sig.Results().At(i).Type()}
+				results = append(results, e.result(i, rt))
+			}
+			return results, ""
+		default:
+			t.Fatalf("%v: can't evaluate %v", f.Name, b.LongString())
+		}
+		b, pred = next.Block(), next.Index()
+	}
+	t.Fatalf("%v: didn't return after %v blocks", f.Name, maxSteps)
+	return nil, ""
+}
+
+// result returns the i'th result, of type t, from its slot.
+func (e *evaluator) result(i int, t *Type) interface{} {
+	for n, obj := range e.objects {
+		if ret, ok := n.(*ssaRetVar); ok && ret.idx == i {
+			return load(t, ptr{obj, 0})
+		}
+	}
+	return zero(t)
+}
+
+// object returns the object of the symbol sym.
+func (e *evaluator) object(sym interface{}) *object {
+	var n ssa.GCNode
+	switch sym := sym.(type) {
+	case *ssa.ArgSymbol:
+		n = sym.Node
+	case *ssa.AutoSymbol:
+		n = sym.Node
+	default:
+		e.t.Fatalf("%v: unknown symbol %v", e.f.Name, sym)
+	}
+	obj, ok := e.objects[n]
+	if !ok {
+		obj = &object{map[int64]interface{}{}}
+		if p, ok := n.(*ssaParam); ok && p.sig != nil {
+			// the parameter's address is taken
+			store(p.Typ().(*Type), ptr{obj, 0}, e.args[p.idx])
+		}
+		e.objects[n] = obj
+	}
+	return obj
+}
+
+// value returns the value of v, evaluating it if it's in the current
+// block and it hasn't been in this visit of the block.
+func (e *evaluator) value(v *ssa.Value) interface{} {
+	if v.Block != e.cur || e.visit[v] == e.n {
+		x, ok := e.vals[v]
+		if !ok {
+			e.t.Fatalf("%v: %v is used before it's evaluated", e.f.Name, v.LongString())
+		}
+		return x
+	}
+	x := e.eval(v)
+	e.vals[v] = x
+	e.visit[v] = e.n
+	return x
+}
+
+// intOps are the integer ops, taking and returning unsigned integers of
+// size bytes.
+var intOps = map[ssa.Op]struct {
+	size int64
+	f    func(x, y uint64, size int64) uint64
+}{
+	ssa.OpAdd8: {1, add}, ssa.OpAdd16: {2, add}, ssa.OpAdd32: {4, add}, ssa.OpAdd64: {8, add},
+	ssa.OpSub8: {1, sub}, ssa.OpSub16: {2, sub}, ssa.OpSub32: {4, sub}, ssa.OpSub64: {8, sub},
+	ssa.OpMul8: {1, mul}, ssa.OpMul16: {2, mul}, ssa.OpMul32: {4, mul}, ssa.OpMul64: {8, mul},
+	ssa.OpAnd8: {1, and}, ssa.OpAnd16: {2, and}, ssa.OpAnd32: {4, and}, ssa.OpAnd64: {8, and},
+	ssa.OpOr8: {1, or}, ssa.OpOr16: {2, or}, ssa.OpOr32: {4, or}, ssa.OpOr64: {8, or},
+	ssa.OpXor8: {1, xor}, ssa.OpXor16: {2, xor}, ssa.OpXor32: {4, xor}, ssa.OpXor64: {8, xor},
+	ssa.OpDiv8: {1, div}, ssa.OpDiv16: {2, div}, ssa.OpDiv32: {4, div}, ssa.OpDiv64: {8, div},
+	ssa.OpDiv8u: {1, divu}, ssa.OpDiv16u: {2, divu}, ssa.OpDiv32u: {4, divu}, ssa.OpDiv64u: {8, divu},
+	ssa.OpMod8: {1, mod}, ssa.OpMod16: {2, mod}, ssa.OpMod32: {4, mod}, ssa.OpMod64: {8, mod},
+	ssa.OpMod8u: {1, modu}, ssa.OpMod16u: {2, modu}, ssa.OpMod32u: {4, modu}, ssa.OpMod64u: {8, modu},
+	ssa.OpHmul8: {1, hmul}, ssa.OpHmul16: {2, hmul}, ssa.OpHmul32: {4, hmul},
+	ssa.OpHmul8u: {1, hmulu}, ssa.OpHmul16u: {2, hmulu}, ssa.OpHmul32u: {4, hmulu},
+	ssa.OpEq8: {1, eq}, ssa.OpEq16: {2, eq}, ssa.OpEq32: {4, eq}, ssa.OpEq64: {8, eq},
+	ssa.OpNeq8: {1, neq}, ssa.OpNeq16: {2, neq}, ssa.OpNeq32: {4, neq}, ssa.OpNeq64: {8, neq},
+	ssa.OpLess8: {1, less}, ssa.OpLess16: {2, less}, ssa.OpLess32: {4, less}, ssa.OpLess64: {8, less},
+	ssa.OpLess8U: {1, lessu}, ssa.OpLess16U: {2, lessu}, ssa.OpLess32U: {4, lessu}, ssa.OpLess64U: {8, lessu},
+	ssa.OpLeq8: {1, leq}, ssa.OpLeq16: {2, leq}, ssa.OpLeq32: {4, leq}, ssa.OpLeq64: {8, leq},
+	ssa.OpLeq8U: {1, lequ}, ssa.OpLeq16U: {2, lequ}, ssa.OpLeq32U: {4, lequ}, ssa.OpLeq64U: {8, lequ},
+	ssa.OpGreater8: {1, greater}, ssa.OpGreater16: {2, greater}, ssa.OpGreater32: {4, greater}, ssa.OpGreater64: {8, greater},
+	ssa.OpGreater8U: {1, greateru}, ssa.OpGreater16U: {2, greateru}, ssa.OpGreater32U: {4, greateru}, ssa.OpGreater64U: {8, greateru},
+	ssa.OpGeq8: {1, geq}, ssa.OpGeq16: {2, geq}, ssa.OpGeq32: {4, geq}, ssa.OpGeq64: {8, geq},
+	ssa.OpGeq8U: {1, gequ}, ssa.OpGeq16U: {2, gequ}, ssa.OpGeq32U: {4, gequ}, ssa.OpGeq64U: {8, gequ},
+	// the size of a shift is the size of the shifted value, the count
+	// is unsigned and has the size of its own type
+	ssa.OpLsh8x8: {1, lsh}, ssa.OpLsh8x16: {1, lsh}, ssa.OpLsh8x32: {1, lsh}, ssa.OpLsh8x64: {1, lsh},
+	ssa.OpLsh16x8: {2, lsh}, ssa.OpLsh16x16: {2, lsh}, ssa.OpLsh16x32: {2, lsh}, ssa.OpLsh16x64: {2, lsh},
+	ssa.OpLsh32x8: {4, lsh}, ssa.OpLsh32x16: {4, lsh}, ssa.OpLsh32x32: {4, lsh}, ssa.OpLsh32x64: {4, lsh},
+	ssa.OpLsh64x8: {8, lsh}, ssa.OpLsh64x16: {8, lsh}, ssa.OpLsh64x32: {8, lsh}, ssa.OpLsh64x64: {8, lsh},
+	ssa.OpRsh8Ux8: {1, rshu}, ssa.OpRsh8Ux16: {1, rshu}, ssa.OpRsh8Ux32: {1, rshu}, ssa.OpRsh8Ux64: {1, rshu},
+	ssa.OpRsh16Ux8: {2, rshu}, ssa.OpRsh16Ux16: {2, rshu}, ssa.OpRsh16Ux32: {2, rshu}, ssa.OpRsh16Ux64: {2, rshu},
+	ssa.OpRsh32Ux8: {4, rshu}, ssa.OpRsh32Ux16: {4, rshu}, ssa.OpRsh32Ux32: {4, rshu}, ssa.OpRsh32Ux64: {4, rshu},
+	ssa.OpRsh64Ux8: {8, rshu}, ssa.OpRsh64Ux16: {8, rshu}, ssa.OpRsh64Ux32: {8, rshu}, ssa.OpRsh64Ux64: {8, rshu},
+	ssa.OpRsh8x8: {1, rsh}, ssa.OpRsh8x16: {1, rsh}, ssa.OpRsh8x32: {1, rsh}, ssa.OpRsh8x64: {1, rsh},
+	ssa.OpRsh16x8: {2, rsh}, ssa.OpRsh16x16: {2, rsh}, ssa.OpRsh16x32: {2, rsh}, ssa.OpRsh16x64: {2, rsh},
+	ssa.OpRsh32x8: {4, rsh}, ssa.OpRsh32x16: {4, rsh}, ssa.OpRsh32x32: {4, rsh}, ssa.OpRsh32x64: {4, rsh},
+	ssa.OpRsh64x8: {8, rsh}, ssa.OpRsh64x16: {8, rsh}, ssa.OpRsh64x32: {8, rsh}, ssa.OpRsh64x64: {8, rsh},
+}
+
+func add(x, y uint64, size int64) uint64 { return x + y }
+func sub(x, y uint64, size int64) uint64 { return x - y }
+func mul(x, y uint64, size int64) uint64 { return x * y }
+func and(x, y uint64, size int64) uint64 { return x & y }
+func or(x, y uint64, size int64) uint64  { return x | y }
+func xor(x, y uint64, size int64) uint64 { return x ^ y }
+
+func div(x, y uint64, size int64) uint64 {
+	if y == 0 {
+		panic(evalPanic("divide"))
+	}
+	return uint64(sext(x, size) / sext(y, size))
+}
+
+func divu(x, y uint64, size int64) uint64 {
+	if y == 0 {
+		panic(evalPanic("divide"))
+	}
+	return x / y
+}
+
+func mod(x, y uint64, size int64) uint64 {
+	if y == 0 {
+		panic(evalPanic("divide"))
+	}
+	return uint64(sext(x, size) % sext(y, size))
+}
+
+func modu(x, y uint64, size int64) uint64 {
+	if y == 0 {
+		panic(evalPanic("divide"))
+	}
+	return x % y
+}
+
+func hmul(x, y uint64, size int64) uint64 {
+	return uint64(sext(x, size) * sext(y, size) >> uint(size*8))
+}
+
+func hmulu(x, y uint64, size int64) uint64 { return x * y >> uint(size*8) }
+
+func boolVal(b bool) uint64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func eq(x, y uint64, size int64) uint64       { return boolVal(x == y) }
+func neq(x, y uint64, size int64) uint64      { return boolVal(x != y) }
+func less(x, y uint64, size int64) uint64     { return boolVal(sext(x, size) < sext(y, size)) }
+func lessu(x, y uint64, size int64) uint64    { return boolVal(x < y) }
+func leq(x, y uint64, size int64) uint64      { return boolVal(sext(x, size) <= sext(y, size)) }
+func lequ(x, y uint64, size int64) uint64     { return boolVal(x <= y) }
+func greater(x, y uint64, size int64) uint64  { return boolVal(sext(x, size) > sext(y, size)) }
+func greateru(x, y uint64, size int64) uint64 { return boolVal(x > y) }
+func geq(x, y uint64, size int64) uint64      { return boolVal(sext(x, size) >= sext(y, size)) }
+func gequ(x, y uint64, size int64) uint64     { return boolVal(x >= y) }
+
+func lsh(x, y uint64, size int64) uint64 {
+	if y >= uint64(size*8) {
+		return 0
+	}
+	return x << y
+}
+
+func rshu(x, y uint64, size int64) uint64 {
+	if y >= uint64(size*8) {
+		return 0
+	}
+	return x >> y
+}
+
+func rsh(x, y uint64, size int64) uint64 {
+	if y >= 64 {
+		y = 63
+	}
+	return uint64(sext(x, size) >> y)
+}
+
+// floatOps are the float ops, the float32 ones round their results.
+var floatOps = map[ssa.Op]struct {
+	size int64
+	f    func(x, y float64) float64
+}{
+	ssa.OpAdd32F: {4, func(x, y float64) float64 { return x + y }},
+	ssa.OpAdd64F: {8, func(x, y float64) float64 { return x + y }},
+	ssa.OpSub32F: {4, func(x, y float64) float64 { return x - y }},
+	ssa.OpSub64F: {8, func(x, y float64) float64 { return x - y }},
+	ssa.OpMul32F: {4, func(x, y float64) float64 { return x * y }},
+	ssa.OpMul64F: {8, func(x, y float64) float64 { return x * y }},
+	ssa.OpDiv32F: {4, func(x, y float64) float64 { return x / y }},
+	ssa.OpDiv64F: {8, func(x, y float64) float64 { return x / y }},
+}
+
+// floatCmps are the float comparisons.
+var floatCmps = map[ssa.Op]func(x, y float64) bool{
+	ssa.OpEq32F:      func(x, y float64) bool { return x == y },
+	ssa.OpEq64F:      func(x, y float64) bool { return x == y },
+	ssa.OpNeq32F:     func(x, y float64) bool { return x != y },
+	ssa.OpNeq64F:     func(x, y float64) bool { return x != y },
+	ssa.OpLess32F:    func(x, y float64) bool { return x < y },
+	ssa.OpLess64F:    func(x, y float64) bool { return x < y },
+	ssa.OpLeq32F:     func(x, y float64) bool { return x <= y },
+	ssa.OpLeq64F:     func(x, y float64) bool { return x <= y },
+	ssa.OpGreater32F: func(x, y float64) bool { return x > y },
+	ssa.OpGreater64F: func(x, y float64) bool { return x > y },
+	ssa.OpGeq32F:     func(x, y float64) bool { return x >= y },
+	ssa.OpGeq64F:     func(x, y float64) bool { return x >= y },
+}
+
+// extOps are the sign and zero extensions and the truncations, from and
+// to sizes in bytes.
+var extOps = map[ssa.Op]struct {
+	from, to int64
+	signed   bool
+}{
+	ssa.OpSignExt8to16: {1, 2, true}, ssa.OpSignExt8to32: {1, 4, true}, ssa.OpSignExt8to64: {1, 8, true},
+	ssa.OpSignExt16to32: {2, 4, true}, ssa.OpSignExt16to64: {2, 8, true}, ssa.OpSignExt32to64: {4, 8, true},
+	ssa.OpZeroExt8to16: {1, 2, false}, ssa.OpZeroExt8to32: {1, 4, false}, ssa.OpZeroExt8to64: {1, 8, false},
+	ssa.OpZeroExt16to32: {2, 4, false}, ssa.OpZeroExt16to64: {2, 8, false}, ssa.OpZeroExt32to64: {4, 8, false},
+	ssa.OpTrunc16to8: {2, 1, false}, ssa.OpTrunc32to8: {4, 1, false}, ssa.OpTrunc32to16: {4, 2, false},
+	ssa.OpTrunc64to8: {8, 1, false}, ssa.OpTrunc64to16: {8, 2, false}, ssa.OpTrunc64to32: {8, 4, false},
+}
+
+// cvtOps are the conversions between integers and floats, from and to
+// sizes in bytes.
+var cvtOps = map[ssa.Op]struct {
+	from, to   int64
+	fromF, toF bool
+}{
+	ssa.OpCvt32to32F: {4, 4, false, true}, ssa.OpCvt32to64F: {4, 8, false, true},
+	ssa.OpCvt64to32F: {8, 4, false, true}, ssa.OpCvt64to64F: {8, 8, false, true},
+	ssa.OpCvt32Fto32: {4, 4, true, false}, ssa.OpCvt32Fto64: {4, 8, true, false},
+	ssa.OpCvt64Fto32: {8, 4, true, false}, ssa.OpCvt64Fto64: {8, 8, true, false},
+	ssa.OpCvt32Fto64F: {4, 8, true, true}, ssa.OpCvt64Fto32F: {8, 4, true, true},
+}
+
+// cvt converts x as the op with the sizes from and to does, float to
+// integer conversions out of range give the "integer indefinite" value
+// 1<<(to*8-1), as the AMD64 instructions do.
+func cvt(x interface{}, from, to int64, fromF, toF bool) interface{} {
+	switch {
+	case !fromF:
+		i := sext(x.(uint64), from)
+		if to == 4 {
+			return float64(float32(i))
+		}
+		return float64(i)
+	case toF:
+		if to == 4 {
+			return float64(float32(x.(float64)))
+		}
+		return x.(float64)
+	}
+	f := x.(float64)
+	min := -math.Ldexp(1, int(to*8-1))
+	if f != f || f < min || f >= -min {
+		return uint64(1) << uint(to*8-1)
+	}
+	return mask(uint64(int64(f)), to)
+}
+
+// eval evaluates v.
+func (e *evaluator) eval(v *ssa.Value) interface{} {
+	arg := func(i int) interface{} { return e.value(v.Args[i]) }
+	if op, ok := intOps[v.Op]; ok {
+		return mask(op.f(arg(0).(uint64), arg(1).(uint64), op.size), v.Type.Size())
+	}
+	if op, ok := floatOps[v.Op]; ok {
+		x := op.f(arg(0).(float64), arg(1).(float64))
+		if op.size == 4 {
+			x = float64(float32(x))
+		}
+		return x
+	}
+	if cmp, ok := floatCmps[v.Op]; ok {
+		return boolVal(cmp(arg(0).(float64), arg(1).(float64)))
+	}
+	if op, ok := extOps[v.Op]; ok {
+		x := arg(0).(uint64)
+		if op.signed {
+			return mask(uint64(sext(x, op.from)), op.to)
+		}
+		return mask(x, op.to)
+	}
+	if op, ok := cvtOps[v.Op]; ok {
+		return cvt(arg(0), op.from, op.to, op.fromF, op.toF)
+	}
+	switch v.Op {
+	case ssa.OpInitMem, ssa.OpSP, ssa.OpSB:
+		return nil
+	case ssa.OpArg:
+		return e.args[v.Aux.(*ssaParam).idx]
+	case ssa.OpCopy:
+		return arg(0)
+	case ssa.OpConstBool, ssa.OpConst8, ssa.OpConst16, ssa.OpConst32, ssa.OpConst64:
+		return mask(uint64(v.AuxInt), v.Type.Size())
+	case ssa.OpConst32F, ssa.OpConst64F:
+		return math.Float64frombits(uint64(v.AuxInt))
+	case ssa.OpConstNil:
+		return ptr{}
+	case ssa.OpConstSlice:
+		return sliceVal{}
+	case ssa.OpConstInterface:
+		return iface{}
+	case ssa.OpAMD64MOVOconst:
+		return vector{}
+	case ssa.OpConstString:
+		var b []interface{}
+		for _, c := range []byte(v.Aux.(string)) {
+			b = append(b, uint64(c))
+		}
+		return sliceVal{newArray(Typ[types.Uint8], b), uint64(len(b)), uint64(len(b))}
+	case ssa.OpNeg8, ssa.OpNeg16, ssa.OpNeg32, ssa.OpNeg64:
+		return mask(-arg(0).(uint64), v.Type.Size())
+	case ssa.OpCom8, ssa.OpCom16, ssa.OpCom32, ssa.OpCom64:
+		return mask(^arg(0).(uint64), v.Type.Size())
+	case ssa.OpNot:
+		return 1 - arg(0).(uint64)
+	case ssa.OpLrot8, ssa.OpLrot16, ssa.OpLrot32, ssa.OpLrot64:
+		x, n, size := arg(0).(uint64), uint64(v.AuxInt), v.Type.Size()
+		return mask(x<<n|x>>(uint64(size*8)-n), size)
+	case ssa.OpNeg32F:
+		return float64(-float32(arg(0).(float64)))
+	case ssa.OpNeg64F:
+		return -arg(0).(float64)
+	case ssa.OpSqrt:
+		return math.Sqrt(arg(0).(float64))
+	case ssa.OpComplexMake:
+		return complex(arg(0).(float64), arg(1).(float64))
+	case ssa.OpComplexReal:
+		return real(arg(0).(complex128))
+	case ssa.OpComplexImag:
+		return imag(arg(0).(complex128))
+	case ssa.OpStringMake:
+		l := arg(1).(uint64)
+		return sliceVal{arg(0).(ptr), l, l}
+	case ssa.OpSliceMake:
+		return sliceVal{arg(0).(ptr), arg(1).(uint64), arg(2).(uint64)}
+	case ssa.OpStringPtr, ssa.OpSlicePtr:
+		return arg(0).(sliceVal).ptr
+	case ssa.OpStringLen, ssa.OpSliceLen:
+		return arg(0).(sliceVal).len
+	case ssa.OpSliceCap:
+		return arg(0).(sliceVal).cap
+	case ssa.OpEqSlice:
+		return boolVal(arg(0).(sliceVal).ptr == arg(1).(sliceVal).ptr)
+	case ssa.OpNeqSlice:
+		return boolVal(arg(0).(sliceVal).ptr != arg(1).(sliceVal).ptr)
+	case ssa.OpEqPtr:
+		return boolVal(arg(0).(ptr) == arg(1).(ptr))
+	case ssa.OpNeqPtr:
+		return boolVal(arg(0).(ptr) != arg(1).(ptr))
+	case ssa.OpEqInter:
+		return boolVal(arg(0).(iface).itab == arg(1).(iface).itab)
+	case ssa.OpNeqInter:
+		return boolVal(arg(0).(iface).itab != arg(1).(iface).itab)
+	case ssa.OpIMake:
+		return iface{arg(0).(ptr), arg(1).(ptr)}
+	case ssa.OpITab:
+		return arg(0).(iface).itab
+	case ssa.OpIData:
+		return arg(0).(iface).data
+	case ssa.OpStructMake0, ssa.OpStructMake1, ssa.OpStructMake2, ssa.OpStructMake3, ssa.OpStructMake4:
+		fields := []interface{}{}
+		for i := range v.Args {
+			fields = append(fields, arg(i))
+		}
+		return fields
+	case ssa.OpStructSelect:
+		return arg(0).([]interface{})[v.AuxInt]
+	case ssa.OpAddr:
+		return ptr{e.object(v.Aux), 0}
+	case ssa.OpOffPtr:
+		p := arg(0).(ptr)
+		return ptr{p.obj, p.off + v.AuxInt}
+	case ssa.OpAddPtr:
+		p := arg(0).(ptr)
+		return ptr{p.obj, p.off + int64(arg(1).(uint64))}
+	case ssa.OpPtrIndex:
+		p := arg(0).(ptr)
+		return ptr{p.obj, p.off + int64(arg(1).(uint64))*v.Type.ElemType().Size()}
+	case ssa.OpIsInBounds:
+		return boolVal(arg(0).(uint64) < arg(1).(uint64))
+	case ssa.OpIsSliceInBounds:
+		return boolVal(arg(0).(uint64) <= arg(1).(uint64))
+	case ssa.OpNilCheck:
+		if arg(0).(ptr).obj == nil {
+			panic(evalPanic("nil"))
+		}
+		return nil
+	case ssa.OpLoad:
+		arg(1)
+		return load(v.Type.(*Type), arg(0).(ptr))
+	case ssa.OpStore:
+		arg(2)
+		store(v.Args[1].Type.(*Type), arg(0).(ptr), arg(1))
+		return nil
+	case ssa.OpAMD64MOVOload:
+		// the values have type ssa.TypeInt128, a vector is stored whole
+		arg(1)
+		p := arg(0).(ptr)
+		if p.obj == nil {
+			panic(evalPanic("nil"))
+		}
+		if x, ok := p.obj.vals[p.off]; ok {
+			return x
+		}
+		return vector{}
+	case ssa.OpAMD64MOVOstore:
+		arg(2)
+		p := arg(0).(ptr)
+		if p.obj == nil {
+			panic(evalPanic("nil"))
+		}
+		p.obj.vals[p.off] = arg(1)
+		return nil
+	case ssa.OpZero:
+		arg(1)
+		p := arg(0).(ptr)
+		for off := range p.obj.vals {
+			if off >= p.off && off < p.off+v.AuxInt {
+				delete(p.obj.vals, off)
+			}
+		}
+		return nil
+	case ssa.OpStaticCall:
+		arg(0)
+		panic(evalPanic(v.Aux.(*LSym).Name))
+	}
+	e.t.Fatalf("%v: can't evaluate %v", e.f.Name, v.LongString())
+	return nil
+}
@@ -0,0 +1,237 @@
+package ssair
+
+import (
+	"go/ast"
+	"go/constant"
+	"go/types"
+
+	"github.com/bjwbell/ssa"
+)
+
+// isIntrinsicCall reports whether call is a call of ssair.Op1 or ssair.Op2.
+func isIntrinsicCall(info *types.Info, call *ast.CallExpr) bool {
+	return isPkgFunc(info, call, "Op1") || isPkgFunc(info, call, "Op2")
+}
+
+// isOpValue reports whether t is ssair.OpValue, the result type of the
+// intrinsics.
+func isOpValue(t types.Type) bool {
+	named, ok := t.(*types.Named)
+	if !ok {
+		return false
+	}
+	obj := named.Obj()
+	return obj.Pkg() != nil && obj.Pkg().Path() == pkgPath && obj.Name() == "OpValue"
+}
+
+// operandClass is the class of the operands of an intrinsic.
+type operandClass int
+
+const (
+	gp64 operandClass = iota // 64 bit integers and pointers
+	gp32                     // 32 bit integers
+	fp64                     // float64
+	fp32                     // float32
+	xmm                      // SSE vectors
+)
+
+// match reports whether a value of type t is in the operand class c.
+func (c operandClass) match(t *Type) bool {
+	switch c {
+	case gp64:
+		return t.IsInteger() && t.Size() == 8 || t.IsPtr()
+	case gp32:
+		return t.IsInteger() && t.Size() == 4
+	case fp64:
+		return t.IsFloat() && t.Size() == 8
+	case fp32:
+		return t.IsFloat() && t.Size() == 4
+	case xmm:
+		return t.IsVector()
+	}
+	return false
+}
+
+// intrinsic describes a machine op that can be called with ssair.Op1 or
+// ssair.Op2.
+type intrinsic struct {
+	args   int          // number of operands
+	class  operandClass // class of the operands, which all have one type
+	result *Type        // type of the result, nil if it's the operand type
+}
+
+// intrinsics are the machine ops that can be called directly. The ssa
+// package doesn't export its op definitions (opcodeTable), so the ones
+// that can be called are described here.
+var intrinsics = map[ssa.Op]intrinsic{
+	ssa.OpAMD64ADDQ: {2, gp64, nil},
+	ssa.OpAMD64SUBQ: {2, gp64, nil},
+	ssa.OpAMD64MULQ: {2, gp64, nil},
+	ssa.OpAMD64ANDQ: {2, gp64, nil},
+	ssa.OpAMD64ORQ:  {2, gp64, nil},
+	ssa.OpAMD64XORQ: {2, gp64, nil},
+	ssa.OpAMD64NEGQ: {1, gp64, nil},
+	ssa.OpAMD64NOTQ: {1, gp64, nil},
+
+	ssa.OpAMD64ADDL: {2, gp32, nil},
+	ssa.OpAMD64SUBL: {2, gp32, nil},
+	ssa.OpAMD64MULL: {2, gp32, nil},
+	ssa.OpAMD64ANDL: {2, gp32, nil},
+	ssa.OpAMD64ORL:  {2, gp32, nil},
+	ssa.OpAMD64XORL: {2, gp32, nil},
+	ssa.OpAMD64NEGL: {1, gp32, nil},
+	ssa.OpAMD64NOTL: {1, gp32, nil},
+
+	ssa.OpAMD64ADDSD:  {2, fp64, nil},
+	ssa.OpAMD64SUBSD:  {2, fp64, nil},
+	ssa.OpAMD64MULSD:  {2, fp64, nil},
+	ssa.OpAMD64DIVSD:  {2, fp64, nil},
+	ssa.OpAMD64SQRTSD: {1, fp64, nil},
+
+	ssa.OpAMD64ADDSS: {2, fp32, nil},
+	ssa.OpAMD64SUBSS: {2, fp32, nil},
+	ssa.OpAMD64MULSS: {2, fp32, nil},
+	ssa.OpAMD64DIVSS: {2, fp32, nil},
+
+	ssa.OpAMD64CVTTSD2SQ: {1, fp64, Typ[types.Int64]},
+	ssa.OpAMD64CVTSQ2SD:  {1, gp64, Typ[types.Float64]},
+	ssa.OpAMD64CVTSD2SS:  {1, fp64, Typ[types.Float32]},
+	ssa.OpAMD64CVTSS2SD:  {1, fp32, Typ[types.Float64]},
+
+	ssa.OpAMD64PXOR: {2, xmm, nil},
+}
+
+// intrinsicOp returns the op of the intrinsic call and its definition,
+// the call must have the number of operands the op takes.
+func (s *state) intrinsicOp(call *ast.CallExpr) (ssa.Op, intrinsic) {
+	opValue := s.ctx.fn.Types[call.Args[0]].Value
+	if opValue == nil || opValue.Kind() != constant.Int {
+		s.Errorf("intrinsic op must be an ssa.Op constant")
+	}
+	i, _ := constant.Int64Val(opValue)
+	op := ssa.Op(i)
+	in, ok := intrinsics[op]
+	if !ok {
+		s.Errorf("%v isn't a supported intrinsic", op)
+	}
+	if n := len(call.Args) - 1; n != in.args {
+		s.Errorf("%v takes %v operands, not %v", op, in.args, n)
+	}
+	return op, in
+}
+
+// intrinsic converts the call ssair.Op1(op, x) to the machine op applied
+// to x and ssair.Op2(op, x, y) to the op applied to x and y. The operands
+// must have the same type, which must be in the operand class of op.
+func (s *state) intrinsic(call *ast.CallExpr) *ssa.Value {
+	op, in := s.intrinsicOp(call)
+	t := s.operandType(call)
+	if t == nil {
+		s.Errorf("%v operand %v has type OpValue, and no value of a machine type is assigned to it", op, call.Args[1])
+	}
+	if !in.class.match(t) {
+		s.Errorf("%v operand %v has type %v, which isn't in the operand class of the op", op, call.Args[1], t)
+	}
+	var args []*ssa.Value
+	for _, x := range call.Args[1:] {
+		if u := s.exprType(x); !u.Equal(t) {
+			s.Errorf("%v operand %v has type %v, not %v", op, x, u, t)
+		}
+		args = append(args, s.expr(ExprNode(x, s.ctx)))
+	}
+	rt := in.result
+	if rt == nil {
+		rt = t
+	}
+	v := s.newValue0(op, ssaType(rt))
+	v.AddArgs(args...)
+	return v
+}
+
+// intrinsicType returns the type of the result of the intrinsic call. It
+// returns nil if the type isn't known, the result has the type of the
+// operands and the first is an OpValue variable whose type isn't set.
+func (s *state) intrinsicType(call *ast.CallExpr) *Type {
+	if _, in := s.intrinsicOp(call); in.result != nil {
+		return in.result
+	}
+	return s.operandType(call)
+}
+
+// operandType returns the type of the first operand of the intrinsic
+// call, or nil if it's an OpValue variable whose type isn't set.
+func (s *state) operandType(call *ast.CallExpr) *Type {
+	t := s.exprType(call.Args[1])
+	if isOpValue(t.Type) {
+		return nil
+	}
+	return t
+}
+
+// opValueType returns the type of the values of rhs, when it's assigned
+// to an OpValue variable, or nil if it isn't known yet.
+func (s *state) opValueType(rhs ast.Expr) *Type {
+	if call, ok := unparen(rhs).(*ast.CallExpr); ok && isIntrinsicCall(s.ctx.fn, call) {
+		return s.intrinsicType(call)
+	}
+	t := s.exprType(rhs)
+	if isOpValue(t.Type) {
+		return nil
+	}
+	return t
+}
+
+// opValueTypes sets the types of the OpValue variables in body, the
+// variables the results of intrinsics are assigned to, to the types of
+// the values assigned to them. The values can be OpValue variables too,
+// so the types are propagated until none change.
+func (s *state) opValueTypes(body *ast.BlockStmt) {
+	type def struct {
+		lhs *ast.Ident
+		rhs ast.Expr
+	}
+	var defs []def
+	add := func(lhs, rhs []ast.Expr) {
+		if len(lhs) != len(rhs) {
+			return
+		}
+		for i, l := range lhs {
+			id, ok := unparen(l).(*ast.Ident)
+			if !ok || id.Name == "_" || !isOpValue(s.ctx.fn.ObjectOf(id).Type()) {
+				continue
+			}
+			defs = append(defs, def{id, rhs[i]})
+		}
+	}
+	ast.Inspect(body, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.AssignStmt:
+			add(n.Lhs, n.Rhs)
+		case *ast.ValueSpec:
+			var names []ast.Expr
+			for _, name := range n.Names {
+				names = append(names, name)
+			}
+			add(names, n.Values)
+		}
+		return true
+	})
+	for changed := true; changed; {
+		changed = false
+		for _, d := range defs {
+			t := s.opValueType(d.rhs)
+			if t == nil {
+				continue
+			}
+			obj := s.ctx.fn.ObjectOf(d.lhs)
+			if old, ok := s.ctx.opTypes[obj]; ok {
+				if !types.Identical(old, t.Type) {
+					s.Errorf("%v is assigned values of type %v and %v", d.lhs.Name, old, t)
+				}
+				continue
+			}
+			s.ctx.opTypes[obj] = t.Type
+			changed = true
+		}
+	}
+}
@@ -3,6 +3,7 @@ package ssair
 import (
 	"fmt"
 	"go/ast"
+	"go/types"
 
 	"github.com/bjwbell/ssa"
 )
@@ -22,16 +23,17 @@ func (n *Node) String() string {
 }
 
 func (n *Node) Typ() ssa.Type {
+	var t types.Type
 	switch node := n.node.(type) {
 	case *ast.Ident:
-		t := &Type{n.ctx.fn.ObjectOf(node).Type()}
-		return t
+		t = n.ctx.varType(n.ctx.fn.ObjectOf(node))
 	case ast.Expr:
-		t := &Type{n.ctx.fn.TypeOf(node)}
-		return t
+		t = n.ctx.fn.TypeOf(node)
 	default:
 		panic("can't get type of node")
 	}
+	// untyped constants and comparison results get their default type
+	return &Type{types.Default(t)}
 }
 
 func (n *Node) Name() string {
@@ -53,7 +55,7 @@ func (n *Node) Xoffset() int64 {
 	if n.Var != nil {
 		return n.Var.Xoffset()
 	} else {
-		return 8
+		return 0
 	}
 }
 
@@ -2,12 +2,21 @@ package ssair
 
 import "github.com/bjwbell/ssa"
 
+// OpValue is the result of a machine op, its values have the type of the
+// op's operands.
 type OpValue interface {
 }
 
-// SSE2 types
+// SSE2 types, they have the same underlying type so a conversion between
+// them, e.g. M128(x) with x an M128d, reinterprets the bits of the vector.
 type M128i [16]byte
-type M128 [4]float32
-type M128d [2]float64
+type M128 [16]byte
+type M128d [16]byte
 
-func Op2(op ssa.Op, src OpValue, dst OpValue) { panic("unreachable") }
+// Op2 applies the two operand machine op to x and y, like the instruction
+// "op y, x", and returns the result. It's replaced by the op when the
+// function is built, x and y must have the same type.
+func Op2(op ssa.Op, x OpValue, y OpValue) OpValue { panic("unreachable") }
+
+// Op1 applies the one operand machine op to x and returns the result.
+func Op1(op ssa.Op, x OpValue) OpValue { panic("unreachable") }
@@ -1,5 +1,150 @@
 package ssair
 
-func phi(vars map[string]int) int {
+import (
+	"go/ast"
+	"go/constant"
+	"go/types"
+
+	"github.com/bjwbell/ssa"
+)
+
+// Phi is the explicit phi function of SSA-form source. The keys of vars
+// are the labels of the predecessor blocks (the unlabeled entry block is
+// "_") and the values are the incoming values from those blocks.
+// It's replaced by a phi value when the function is built, calling it
+// directly panics. Phis of other types are written with a function phi
+// of the source package, declared as
+//
+//	func phi(vars map[string]T) T
+//
+// for the type T of the values.
+func Phi(vars map[string]int) int {
 	panic("dummy phi func")
 }
+
+// phiValue is an explicit phi value whose args haven't been added yet.
+type phiValue struct {
+	v    *ssa.Value
+	args map[string]ast.Expr // incoming value keyed by block label
+}
+
+// isPhiCall reports whether call is a call of ssair.Phi, or of a function
+// phi with the signature func(map[string]T) T.
+func isPhiCall(info *types.Info, call *ast.CallExpr) bool {
+	if isPkgFunc(info, call, "Phi") {
+		return true
+	}
+	id, ok := unparen(call.Fun).(*ast.Ident)
+	if !ok || id.Name != "phi" {
+		return false
+	}
+	fn, ok := info.Uses[id].(*types.Func)
+	if !ok {
+		return false
+	}
+	sig := fn.Type().(*types.Signature)
+	if sig.Params().Len() != 1 || sig.Results().Len() != 1 || sig.Variadic() {
+		return false
+	}
+	m, ok := sig.Params().At(0).Type().Underlying().(*types.Map)
+	return ok && types.Identical(m.Key(), types.Typ[types.String]) &&
+		types.Identical(m.Elem(), sig.Results().At(0).Type())
+}
+
+// phiCall returns e if it's a phi call, otherwise nil.
+func (s *state) phiCall(e ast.Expr) *ast.CallExpr {
+	call, ok := unparen(e).(*ast.CallExpr)
+	if !ok || !isPhiCall(s.ctx.fn, call) {
+		return nil
+	}
+	return call
+}
+
+// isPkgFunc reports whether call is a call of the function name of this
+// package, however the package is imported.
+func isPkgFunc(info *types.Info, call *ast.CallExpr, name string) bool {
+	var id *ast.Ident
+	switch fn := unparen(call.Fun).(type) {
+	case *ast.Ident:
+		id = fn
+	case *ast.SelectorExpr:
+		id = fn.Sel
+	default:
+		return false
+	}
+	fn, ok := info.Uses[id].(*types.Func)
+	return ok && fn.Pkg() != nil && fn.Pkg().Path() == pkgPath && fn.Name() == name
+}
+
+// phi converts the phi call, assigned to a variable of type t, to a phi
+// value of type t in the current block. The args are added by
+// resolvePhis, after the predecessors are known.
+func (s *state) phi(call *ast.CallExpr, t *Type) *ssa.Value {
+	if s.curBlock == s.f.Entry {
+		s.Errorf("phi can't be used in the entry block")
+	}
+	if len(call.Args) != 1 {
+		s.Errorf("phi takes a single map literal argument")
+	}
+	lit, ok := call.Args[0].(*ast.CompositeLit)
+	if !ok {
+		s.Errorf("phi argument must be a map literal")
+	}
+	args := map[string]ast.Expr{}
+	for _, elt := range lit.Elts {
+		kv := elt.(*ast.KeyValueExpr)
+		key := s.ctx.fn.Types[kv.Key].Value
+		if key == nil || key.Kind() != constant.String {
+			s.Errorf("phi keys must be block labels")
+		}
+		args[constant.StringVal(key)] = kv.Value
+	}
+	if u := s.exprType(call); !types.Identical(u.Type, t.Type) {
+		s.Errorf("phi of %v values is assigned to a variable of type %v", u, t)
+	}
+	v := s.newValue0(ssa.OpPhi, ssaType(t))
+	s.phis = append(s.phis, &phiValue{v: v, args: args})
+	return v
+}
+
+// resolvePhis adds the args to the explicit phi values, one per
+// predecessor in the order of the predecessors. The value from each
+// predecessor is the value at the end of its labeled block.
+func (s *state) resolvePhis() {
+	for _, p := range s.phis {
+		b := p.v.Block
+		used := map[string]bool{}
+		for _, e := range b.Preds {
+			pred := s.labeled[e.Block()]
+			if pred == nil {
+				s.Errorf("phi predecessor %v of block %v is not a labeled block", e.Block(), b)
+			}
+			arg, ok := p.args[pred.Name()]
+			if !ok {
+				s.Errorf("phi in block %v has no value for predecessor %v", s.labeled[b].Name(), pred.Name())
+			}
+			used[pred.Name()] = true
+			p.v.AddArg(s.phiArg(e.Block(), p.v.Type, arg))
+		}
+		for name := range p.args {
+			if !used[name] {
+				s.Errorf("phi in block %v has a value for %v, which isn't a predecessor", s.labeled[b].Name(), name)
+			}
+		}
+	}
+	s.phis = nil
+}
+
+// phiArg returns the value of arg, a variable or constant, at the end of
+// block b.
+func (s *state) phiArg(b *ssa.Block, t ssa.Type, arg ast.Expr) *ssa.Value {
+	if tv := s.ctx.fn.Types[arg]; tv.Value != nil {
+		// constants are in the entry block
+		return s.expr(ExprNode(arg, s.ctx))
+	}
+	ident, ok := unparen(arg).(*ast.Ident)
+	if !ok {
+		s.Errorf("phi values must be variables or constants")
+	}
+	return s.lookupVarOutgoing(b, t, s.ssaVar(ExprNode(ident, s.ctx)))
+}
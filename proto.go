@@ -1,15 +1,25 @@
 package ssair
 
 import (
+	"bytes"
 	"go/types"
-	"strings"
 )
 
 func GoProto(fn *types.Func) (string, string, string) {
 	pkgname := "package " + fn.Pkg().Name() + "\n"
 	imports := ""
 	signature := fn.Type().(*types.Signature)
-	sig := strings.TrimPrefix(signature.String(), "func(")
-	fnproto := "func " + fn.Name() + "(" + sig + "\n"
+	qualifier := types.RelativeTo(fn.Pkg())
+	recv := ""
+	if r := signature.Recv(); r != nil {
+		name := r.Name()
+		if name == "" {
+			name = "_"
+		}
+		recv = "(" + name + " " + types.TypeString(r.Type(), qualifier) + ") "
+	}
+	var sig bytes.Buffer
+	types.WriteSignature(&sig, signature, qualifier)
+	fnproto := "func " + recv + fn.Name() + sig.String() + "\n"
 	return pkgname, imports, fnproto
 }
@@ -1,11 +1,13 @@
 package ssair
 
 import (
+	"errors"
 	"fmt"
 	"go/ast"
 	"go/constant"
 	"go/token"
 	"go/types"
+	"sort"
 
 	"github.com/bjwbell/ssa"
 )
@@ -62,12 +64,32 @@ type state struct {
 	// all defined variables at the end of each block.  Indexed by block ID.
 	defvars []map[ssaVar]*ssa.Value
 
+	// FwdRef values that haven't been linked to their definitions yet.
+	fwdRefs []*ssa.Value
+
+	// explicit phi values, their args are added once all blocks are built.
+	phis []*phiValue
+
+	// the labeled block each ssa block was generated for.
+	labeled    map[*ssa.Block]*Block
+	curLabeled *Block
+
+	// params, results and locals of the function. Each variable has
+	// exactly one ssaVar, it's the key for the variable in vars.
+	fnVars []ssaVar
+
 	// addresses of PPARAM and PPARAMOUT variables.
 	decladdrs map[ssaVar]*ssa.Value
 
 	// symbols for PEXTERN, PAUTO and PPARAMOUT variables so they can be reused.
 	varsyms map[ssaVar]interface{}
 
+	// variables whose address is taken, they live in memory.
+	addrtaken map[types.Object]bool
+
+	// pragmas from the function's doc comment
+	pragma pragma
+
 	// starting values.  Memory, stack pointer, and globals pointer
 	startmem *ssa.Value
 	sp       *ssa.Value
@@ -81,23 +103,20 @@ type state struct {
 	//labledBlocks    map[string]*ssa.Block
 }
 
-func (s *state) retVar() *ssaRetVar {
-	retVars := getReturnVar(s.ctx, s.fnType)
-	if len(retVars) > 1 {
-		panic("more than one return value is unsupported")
-	}
-	if len(retVars) == 0 {
-		return nil
-	}
-	ret := retVars[0]
-	if ret == nil {
-		panic("nil ret var")
+// retVars returns the results of the function.
+func (s *state) retVars() []*ssaRetVar {
+	var results []*ssaRetVar
+	for _, v := range s.fnVars {
+		if ret, ok := v.(*ssaRetVar); ok {
+			results = append(results, ret)
+		}
 	}
-	return ret
+	return results
 }
 
-func (s *state) retVarAddr() *ssa.Value {
-	ret := s.retVar()
+// retVarAddr returns the address of the i'th result.
+func (s *state) retVarAddr(i int) *ssa.Value {
+	ret := s.retVars()[i]
 	retSym := &ssa.ArgSymbol{Typ: ret.Typ(), Node: ret}
 	aux := retSym
 	retVarAddr := s.entryNewValue1A(ssa.OpAddr, ret.Typ().PtrTo(), aux, s.sp)
@@ -128,10 +147,13 @@ func (s *state) Debug_checknil() bool                            { return s.conf
 var (
 	// dummy node for the memory variable
 	memVar = ssaParam{}
+	// dummy node for the pointer of a slice expression
+	ptrVar = ssaParam{}
+	// dummy node for the type word of an interface conversion
+	typVar = ssaParam{}
 
 // dummy nodes for temporary variables
-/*ptrVar   = Node{Op: ONAME, Class: Pxxx, Sym: &Sym{Name: "ptr"}}
-capVar   = Node{Op: ONAME, Class: Pxxx, Sym: &Sym{Name: "cap"}}
+/*capVar   = Node{Op: ONAME, Class: Pxxx, Sym: &Sym{Name: "cap"}}
 typVar   = Node{Op: ONAME, Class: Pxxx, Sym: &Sym{Name: "typ"}}
 idataVar = Node{Op: ONAME, Class: Pxxx, Sym: &Sym{Name: "idata"}}
 okVar    = Node{Op: ONAME, Class: Pxxx, Sym: &Sym{Name: "ok"}}*/
@@ -143,7 +165,11 @@ func (s *state) startBlock(b *ssa.Block) {
 		s.Fatalf("starting block %v when block %v has not ended", b, s.curBlock)
 	}
 	s.curBlock = b
-	//s.vars = map[*Node]*ssa.Value{}
+	s.vars = map[ssaVar]*ssa.Value{}
+	if s.labeled == nil {
+		s.labeled = map[*ssa.Block]*Block{}
+	}
+	s.labeled[b] = s.curLabeled
 }
 
 // endBlock marks the end of generating code for the current block.
@@ -180,7 +206,7 @@ func (s *state) peekLine() int32 {
 }
 
 func (s *state) Errorf(msg string, args ...interface{}) {
-	panic(msg)
+	panic(fmt.Sprintf(msg, args...))
 }
 
 // newValue0 adds a new value with no arguments to the current block.
@@ -268,17 +294,6 @@ func (s *state) entryNewValue2(op ssa.Op, t ssa.Type, arg0, arg1 *ssa.Value) *ss
 	return s.f.Entry.NewValue2(s.peekLine(), op, t, arg0, arg1)
 }
 
-// PXOR adds a new PXOR value to the entry block.
-func (s *state) PXOR(b *ssa.Block) *ssa.Value {
-	t := Typ[types.Float32]
-	var cnst43 float32
-	cnst43 = 42.0
-
-	val0 := s.f.ConstFloat32(s.peekLine(), t, float64(cnst43))
-	val1 := s.f.ConstFloat32(s.peekLine(), t, float64(cnst43))
-	return b.NewValue2(s.peekLine(), ssa.OpAMD64PXOR, t, val0, val1)
-}
-
 // const* routines add a new const value to the entry block.
 func (s *state) constBool(c bool) *ssa.Value {
 	return s.f.ConstBool(s.peekLine(), Typ[types.Bool], c)
@@ -374,6 +389,18 @@ func (s *state) checkBlock(block *Block) {
 	s.checkLastStmt(block, lastStmt)
 }
 
+// transfersControl reports whether the last statement of a block, stmt,
+// is a goto, if or return.
+func transfersControl(stmt ast.Stmt) bool {
+	switch stmt := stmt.(type) {
+	case *ast.LabeledStmt:
+		return transfersControl(stmt.Stmt)
+	case *ast.BranchStmt, *ast.IfStmt, *ast.SwitchStmt, *ast.ReturnStmt:
+		return true
+	}
+	return false
+}
+
 func (s *state) checkLastStmt(block *Block, stmt ast.Stmt) {
 	if branch, ok := stmt.(*ast.BranchStmt); ok {
 		if branch.Tok != token.GOTO {
@@ -385,15 +412,27 @@ func (s *state) checkLastStmt(block *Block, stmt ast.Stmt) {
 		}
 		s.checkLastStmt(block, lbledStmt.Stmt)
 	} else if ifStmt, ok := stmt.(*ast.IfStmt); ok {
-		_, _, _, err := s.matchIfStmt(ifStmt)
+		_, _, no, err := s.matchIfStmt(ifStmt)
 		if err != nil {
-			s.Errorf(fmt.Sprintf("%v", err))
+			s.Errorf("%v", err)
+		}
+		if no == "" && s.nextBlock(block) == nil {
+			s.Errorf("if statement without else can't end the last block")
+		}
+	} else if switchStmt, ok := stmt.(*ast.SwitchStmt); ok {
+		_, dflt, err := s.matchSwitchStmt(switchStmt)
+		if err != nil {
+			s.Errorf("%v", err)
+		}
+		if dflt == "" && s.nextBlock(block) == nil {
+			s.Errorf("switch statement without default can't end the last block")
 		}
 	} else if _, ok := stmt.(*ast.ReturnStmt); ok {
 		//
 	} else {
-		// the entry block doesn't have to explicitly transfer control
-		if !s.isEntryBlock(block) {
+		// the entry block doesn't have to explicitly transfer control,
+		// with ssair:fallthrough no block has to
+		if !s.isEntryBlock(block) && s.pragma&fallThrough == 0 {
 			s.Errorf("Last stmt must a transfer control")
 		}
 	}
@@ -410,9 +449,26 @@ func (s *state) processBlocks() {
 }
 
 func (s *state) processBlock(block *Block) {
+	s.curLabeled = block
+	if block.b != s.f.Entry {
+		// the entry block is started before its starting values are allocated
+		s.startBlock(block.b)
+	} else {
+		s.labeled[block.b] = block
+	}
 	for _, stmt := range block.stmts {
 		s.stmt(block, stmt)
 	}
+	if !transfersControl(block.stmts[len(block.stmts)-1]) {
+		if next := s.nextBlock(block); next != nil {
+			// fall through to the next block
+			s.curBlock.AddEdgeTo(next.b)
+		} else {
+			// the last block returns, as if it ended in a bare return
+			s.ret(nil)
+		}
+	}
+	s.endBlock()
 }
 
 // body converts the body of fn to SSA and adds it to s.
@@ -479,43 +535,101 @@ func (s *state) getBlockFromName(name string) *Block {
 	return nil
 }
 
-func (s *state) matchIfStmt(stmt *ast.IfStmt) (condIdent *ast.Ident, yesLabel string, noLabel string, err error) {
-	var errored bool
-	var ok bool
+func (s *state) matchIfStmt(stmt *ast.IfStmt) (cond ast.Expr, yesLabel string, noLabel string, err error) {
 	if stmt.Init != nil {
 		s.Errorf("Error: if statement cannot have init expr")
 	}
-	errMsg := "Error: if statement must be of the form \"if t1 { goto lbl1 } else { goto lbl2 }\""
-	if len(stmt.Body.List) != 1 {
-		return nil, "", "", fmt.Errorf(errMsg)
+	errMsg := "Error: if statement must be of the form \"if cond { goto lbl1 } else { goto lbl2 }\" or \"if cond { goto lbl1 }\""
+	yesLabel, ok := gotoLabel(stmt.Body)
+	if !ok {
+		return nil, "", "", errors.New(errMsg)
 	}
-
-	bodyStmt, ok := stmt.Body.List[0].(*ast.BranchStmt)
-	errored = errored || !ok
-
-	if stmt.Else == nil {
-		errored = true
+	if stmt.Else != nil {
+		// without an else the false edge goes to the next block
+		elseBody, ok := stmt.Else.(*ast.BlockStmt)
+		if !ok {
+			return nil, "", "", errors.New(errMsg)
+		}
+		if noLabel, ok = gotoLabel(elseBody); !ok {
+			return nil, "", "", errors.New(errMsg)
+		}
 	}
 
-	elseBody, ok := stmt.Else.(*ast.BlockStmt)
-	errored = errored || !ok
+	cond = stmt.Cond
+	if !isCondExpr(cond) {
+		return nil, "", "", errors.New(errMsg)
+	}
+	return cond, yesLabel, noLabel, nil
+}
 
-	elseStmt, ok := elseBody.List[0].(*ast.BranchStmt)
-	errored = errored || !ok
+// gotoLabel returns the label of body if body is a single goto.
+func gotoLabel(body *ast.BlockStmt) (label string, ok bool) {
+	if len(body.List) != 1 {
+		return "", false
+	}
+	branch, ok := body.List[0].(*ast.BranchStmt)
+	if !ok || branch.Tok != token.GOTO {
+		return "", false
+	}
+	return branch.Label.Name, true
+}
 
-	condIdent, ok = stmt.Cond.(*ast.Ident)
-	errored = errored || !ok
+// switchCase is a case of a switch statement, the value (or the condition
+// of a switch without a tag) and the label of the block it goes to.
+type switchCase struct {
+	expr   ast.Expr
+	label  string
+	target *ssa.Block
+	val    constant.Value // the value of expr if it's a constant
+}
 
-	if errored {
-		return nil, "", "", fmt.Errorf(errMsg)
+// matchSwitchStmt returns the cases of stmt, one per case value, and the
+// default label, or "" if there's no default.
+func (s *state) matchSwitchStmt(stmt *ast.SwitchStmt) (cases []switchCase, dflt string, err error) {
+	if stmt.Init != nil {
+		s.Errorf("Error: switch statement cannot have init stmt")
 	}
+	errMsg := "Error: switch statement cases must be of the form \"case x: goto lbl\""
+	for _, cc := range stmt.Body.List {
+		clause := cc.(*ast.CaseClause)
+		label, ok := gotoLabel(&ast.BlockStmt{List: clause.Body})
+		if !ok {
+			return nil, "", errors.New(errMsg)
+		}
+		if clause.List == nil {
+			dflt = label
+			continue
+		}
+		for _, e := range clause.List {
+			if stmt.Tag == nil && !isCondExpr(e) {
+				// without a tag the cases are if conditions
+				return nil, "", errors.New("Error: the cases of a switch statement without a tag must be conditions, as in an if statement")
+			}
+			cases = append(cases, switchCase{expr: e, label: label})
+		}
+	}
+	return cases, dflt, nil
+}
 
-	yesLabel = bodyStmt.Label.Name
-	noLabel = elseStmt.Label.Name
-	fmt.Println("if condIdent:", condIdent)
-	fmt.Println("if bdyStmt:", bodyStmt)
-	fmt.Println("if elseStmt:", elseStmt)
-	return condIdent, yesLabel, noLabel, nil
+// isCondExpr reports whether cond is a variable, a comparison, or
+// conditions combined with &&, || and !, the conditions allowed in an
+// if statement.
+func isCondExpr(cond ast.Expr) bool {
+	switch cond := cond.(type) {
+	case *ast.Ident:
+		return true
+	case *ast.ParenExpr:
+		return isCondExpr(cond.X)
+	case *ast.UnaryExpr:
+		return cond.Op == token.NOT && isCondExpr(cond.X)
+	case *ast.BinaryExpr:
+		if cond.Op == token.LAND || cond.Op == token.LOR {
+			return isCondExpr(cond.X) && isCondExpr(cond.Y)
+		}
+		op, ok := tokenToOp[cond.Op]
+		return ok && isComparison(op)
+	}
+	return false
 }
 
 // stmt converts the statement stmt to SSA and adds it to s.
@@ -590,7 +704,7 @@ func (s *state) stmt(block *Block, stmt ast.Stmt) {
 			s.fwdGotos = append(s.fwdGotos, n)
 		}
 
-		block.b.AddEdgeTo(lab.target)
+		s.curBlock.AddEdgeTo(lab.target)
 	case *ast.DeclStmt:
 		decl, ok := stmt.Decl.(*ast.GenDecl)
 		if !ok {
@@ -603,9 +717,11 @@ func (s *state) stmt(block *Block, stmt ast.Stmt) {
 		case token.TYPE:
 			panic("internal error")
 		case token.CONST:
-			// panic("unimplementedf")
+			// uses of constants are folded by the type checker
 		case token.VAR:
-			// panic("unimplementedf")
+			for _, spec := range decl.Specs {
+				s.varSpec(spec.(*ast.ValueSpec))
+			}
 		default:
 			panic("internal error")
 		}
@@ -620,8 +736,8 @@ func (s *state) stmt(block *Block, stmt ast.Stmt) {
 			if ok {
 				fnPkg := fmt.Sprintf("%v", fn.X)
 				fnName := fmt.Sprintf("%v", fn.Sel)
-				if fnPkg == "ssair" && fnName == "Op2" {
-					s.PXOR(block.b)
+				if isIntrinsicCall(s.ctx.fn, callexpr) {
+					s.Errorf("the result of %v isn't used", fnName)
 				} else {
 					panic("call expr not implemented: fnPkg - " + fnPkg + ", fnName - " + fnName + ", " + fmt.Sprintf("%#v", expr))
 				}
@@ -632,36 +748,24 @@ func (s *state) stmt(block *Block, stmt ast.Stmt) {
 			panic("default expr not implemented")
 		}
 	case *ast.IfStmt:
-		condIdent, yes, no, err := s.matchIfStmt(stmt)
+		cond, yes, no, err := s.matchIfStmt(stmt)
 		if err != nil {
 			break
 		}
-		c := s.expr(&Node{node: condIdent, ctx: s.ctx})
-		block.b.Kind = ssa.BlockIf
-		block.b.Control = c
-		block.b.Likely = ssa.BranchUnknown
 		yesBlock := s.getBlockFromName(yes)
-		noBlock := s.getBlockFromName(no)
-		block.b.AddEdgeTo(yesBlock.b)
-		block.b.AddEdgeTo(noBlock.b)
-	case *ast.IncDecStmt:
-		panic("todo ast.IncDecStmt")
-	case *ast.ReturnStmt:
-		if len(stmt.Results) > 1 {
-			panic("unsupported: multiple return values")
+		noBlock := s.nextBlock(block)
+		if no != "" {
+			noBlock = s.getBlockFromName(no)
 		}
-		if len(stmt.Results) == 1 {
-			res := stmt.Results[0]
-			node := NewNode(res, s.ctx)
-			t := node.Typ()
-			v := s.expr(node)
-			addr := s.retVarAddr()
-			s.vars[&memVar] = s.newValue3I(ssa.OpStore, ssa.TypeMem, t.Size(), addr, v, s.mem())
+		s.condBranch(cond, yesBlock.b, noBlock.b)
+	case *ast.IncDecStmt:
+		op := OADD
+		if stmt.Tok == token.DEC {
+			op = OSUB
 		}
-		m := s.mem()
-		block.b.Kind = ssa.BlockRet
-		block.b.Control = m
-
+		s.opAssign(op, stmt.X, nil)
+	case *ast.ReturnStmt:
+		s.ret(stmt.Results)
 	case *ast.ForStmt:
 		panic("unsupported: ForStmt")
 	case *ast.GoStmt:
@@ -675,7 +779,18 @@ func (s *state) stmt(block *Block, stmt ast.Stmt) {
 	case *ast.SendStmt:
 		panic("unsupported: SendStmt")
 	case *ast.SwitchStmt:
-		panic("unsupported: SwitchStmt")
+		cases, dflt, err := s.matchSwitchStmt(stmt)
+		if err != nil {
+			break
+		}
+		dfltBlock := s.nextBlock(block)
+		if dflt != "" {
+			dfltBlock = s.getBlockFromName(dflt)
+		}
+		for i := range cases {
+			cases[i].target = s.getBlockFromName(cases[i].label).b
+		}
+		s.switchStmt(stmt.Tag, cases, dfltBlock.b)
 	case *ast.TypeSwitchStmt:
 		panic("unsupported: TypeSwitchStmt")
 	default:
@@ -684,12 +799,43 @@ func (s *state) stmt(block *Block, stmt ast.Stmt) {
 	}
 }
 
+// ret stores the values of the return statement results to the result
+// slots and ends the current block with a return. If there are no values
+// the named results hold them, as in a bare return.
+func (s *state) ret(results []ast.Expr) {
+	retVars := s.retVars()
+	if len(results) != 0 && len(results) != len(retVars) {
+		// includes returning a multi-value call, "return f()"
+		s.Errorf("Return statements must have one value per result")
+		return
+	}
+	values := make([]*ssa.Value, len(retVars))
+	for i, ret := range retVars {
+		if len(results) == 0 {
+			// bare return, the named results hold the values
+			values[i] = s.variable(ret, ret.Typ())
+		} else {
+			values[i] = s.expr(NewNode(results[i], s.ctx))
+		}
+	}
+	for i, ret := range retVars {
+		s.store(ret.Typ().(*Type), s.retVarAddr(i), values[i])
+	}
+	m := s.mem()
+	s.curBlock.Kind = ssa.BlockRet
+	s.curBlock.Control = m
+}
+
 // variable returns the value of a variable at the current location.
 func (s *state) variable(name ssaVar, t ssa.Type) *ssa.Value {
 	v := s.vars[name]
 	if v == nil {
 		// TODO: get type?  Take Sym as arg?
+		if vt, ok := t.(*Type); ok {
+			t = ssaType(vt)
+		}
 		v = s.newValue0A(ssa.OpFwdRef, t, name)
+		s.fwdRefs = append(s.fwdRefs, v)
 		s.vars[name] = v
 	}
 	return v
@@ -706,95 +852,115 @@ func (s *state) linkForwardReferences() {
 	// inserting Phi values as needed.  This is essentially the algorithm
 	// described by Brau, Buchwald, Hack, Leißa, Mallon, and Zwinkau:
 	// http://pp.info.uni-karlsruhe.de/uploads/publikationen/braun13cc.pdf
+	// Looking up a variable in a predecessor can add more FwdRefs, so
+	// work through them with a worklist rather than recursively.
 	for _, b := range s.f.Blocks {
-		for _, v := range b.Values {
-			if v.Op != ssa.OpFwdRef {
-				continue
-			}
-			name := v.Aux.(ssaVar)
-			v.Op = ssa.OpCopy
-			v.Aux = nil
-			v.SetArgs1(s.lookupVarIncoming(b, v.Type, name))
+		for len(s.defvars) <= int(b.ID) {
+			s.defvars = append(s.defvars, nil)
+		}
+		if s.defvars[b.ID] == nil {
+			s.defvars[b.ID] = map[ssaVar]*ssa.Value{}
 		}
 	}
+	s.resolvePhis()
+	for len(s.fwdRefs) > 0 {
+		v := s.fwdRefs[len(s.fwdRefs)-1]
+		s.fwdRefs = s.fwdRefs[:len(s.fwdRefs)-1]
+		s.resolveFwdRef(v)
+	}
 }
 
-// lookupVarIncoming finds the variable's value at the start of block b.
-func (s *state) lookupVarIncoming(b *ssa.Block, t ssa.Type, name ssaVar) *ssa.Value {
-	// TODO(khr): have lookupVarIncoming overwrite the fwdRef or copy it
-	// will be used in, instead of having the result used in a copy value.
+// resolveFwdRef modifies v, a FwdRef, to be the variable's value at the
+// start of its block.
+func (s *state) resolveFwdRef(v *ssa.Value) {
+	b := v.Block
+	name := v.Aux.(ssaVar)
+	v.Aux = nil
 	if b == s.f.Entry {
-		if name == &memVar {
-			return s.startmem
-		}
-		//return nil
-
-		if canSSA(name) {
-			v := s.entryNewValue0A(ssa.OpArg, t, name)
-			// v starts with AuxInt == 0.
-			//s.addNamedValue(name, v)
-			return v
+		// Live variable at start of function.
+		switch {
+		case name == &memVar:
+			v.Op = ssa.OpCopy
+			v.AddArg(s.startmem)
+		case isParamVar(name) && canSSA(name):
+			v.Op = ssa.OpArg
+			v.Aux = name
+		default:
+			// locals start out as the zero value
+			v.Op = ssa.OpCopy
+			v.AddArg(s.zeroVal(name.Typ().(*Type)))
 		}
-		// // variable is live at the entry block.  Load it.
-		// addr := s.decladdrs[name]
-		// if addr == nil {
-		// 	// TODO: closure args reach here.
-		// 	s.Unimplementedf("unhandled closure arg %s at entry to function %s", name, b.Func.Name)
-		// }
-		// if _, ok := addr.Aux.(*ssa.ArgSymbol); !ok {
-		// 	s.Fatalf("variable live at start of function %s is not an argument %s", b.Func.Name, name)
-		// }
-		// return s.entryNewValue2(ssa.OpLoad, t, addr, s.startmem)
-	}
-	var vals []*ssa.Value
-	for _, p := range b.Preds {
-		vals = append(vals, s.lookupVarOutgoing(p.Block(), t, name))
-	}
-	if len(vals) == 0 {
+		return
+	}
+	if len(b.Preds) == 0 {
 		// This block is dead; we have no predecessors and we're not the entry block.
 		// It doesn't matter what we use here as long as it is well-formed,
 		// so use the default/zero value.
-		return nil
-		// if name == &memVar {
-		// 	return s.startmem
-		// }
-		// return s.zeroVal(name.Type)
-	}
-	v0 := vals[0]
-	for i := 1; i < len(vals); i++ {
-		if vals[i] != v0 {
-			// need a phi value
-			v := b.NewValue0(s.peekLine(), ssa.OpPhi, t)
-			v.AddArgs(vals...)
-			//s.addNamedValue(name, v)
-			return v
+		v.Op = ssa.OpCopy
+		if name == &memVar {
+			v.AddArg(s.startmem)
+		} else {
+			v.AddArg(s.zeroVal(name.Typ().(*Type)))
 		}
+		return
+	}
+	// Find variable value on each predecessor.
+	var args []*ssa.Value
+	for _, e := range b.Preds {
+		args = append(args, s.lookupVarOutgoing(e.Block(), v.Type, name))
 	}
-	return v0
+	// Decide if we need a phi or not. We need a phi if there
+	// are two different args (which are both not v).
+	var w *ssa.Value
+	for _, a := range args {
+		if a == v || a == w {
+			// self-reference or already have this witness
+			continue
+		}
+		if w != nil {
+			// two witnesses, need a phi value
+			v.Op = ssa.OpPhi
+			v.AddArgs(args...)
+			return
+		}
+		w = a
+	}
+	if w == nil {
+		s.Fatalf("no witness for reachable phi %s", v)
+	}
+	// One witness. Make v a copy of w.
+	v.Op = ssa.OpCopy
+	v.AddArg(w)
+}
+
+// isParamVar reports whether v is a parameter, params are the only
+// variables with a value at the start of the function.
+func isParamVar(v ssaVar) bool {
+	_, ok := v.(*ssaParam)
+	return ok
 }
 
 // lookupVarOutgoing finds the variable's value at the end of block b.
 func (s *state) lookupVarOutgoing(b *ssa.Block, t ssa.Type, name ssaVar) *ssa.Value {
-	return nil
-	// m := s.defvars[b.ID]
-	// if v, ok := m[name]; ok {
-	// 	return v
-	// }
-	// // The variable is not defined by b and we haven't
-	// // looked it up yet.  Generate v, a copy value which
-	// // will be the outgoing value of the variable.  Then
-	// // look up w, the incoming value of the variable.
-	// // Make v = copy(w).  We need the extra copy to
-	// // prevent infinite recursion when looking up the
-	// // incoming value of the variable.
-	// v := b.NewValue0(s.peekLine(), ssa.OpCopy, t)
-	// m[name] = v
-	// v.AddArg(s.lookupVarIncoming(b, t, name))
-	// return v
+	for {
+		if v, ok := s.defvars[b.ID][name]; ok {
+			return v
+		}
+		// The variable is not defined by b and we haven't looked it up yet.
+		// If b has exactly one predecessor, loop to look it up there.
+		// Otherwise, give up and insert a new FwdRef and resolve it later.
+		if len(b.Preds) != 1 {
+			break
+		}
+		b = b.Preds[0].Block()
+	}
+	// Generate a FwdRef for the variable and return that.
+	v := b.NewValue0A(s.peekLine(), ssa.OpFwdRef, t, name)
+	s.fwdRefs = append(s.fwdRefs, v)
+	s.defvars[b.ID][name] = v
+	return v
 }
 
-// TODO: the above mutually recursive functions can lead to very deep stacks.  Fix that.
-
 func (s *state) addNamedValue(n *Node, v *ssa.Value) {
 	if n.class == Pxxx {
 		// Don't track our dummy nodes (&memVar etc.).
@@ -806,15 +972,12 @@ func (s *state) addNamedValue(n *Node, v *ssa.Value) {
 	if v.Type == nil {
 		panic("nil v.Type (*ssa.Value)")
 	}
-	if n.class == PAUTO && (v.Type.IsString() || v.Type.IsSlice() || v.Type.IsInterface()) {
-		// TODO: can't handle auto compound objects with pointers yet.
-		return
-	}
 	// if n.Class == PAUTO && n.Xoffset != 0 {
 	// 	s.Fatalf("AUTO var with offset %s %d", n, n.Xoffset)
 	// }
 
-	loc := ssa.LocalSlot{N: n, Type: n.Typ(), Off: 0}
+	// all the values of a variable share its slot
+	loc := ssa.LocalSlot{N: s.ssaVar(n), Type: n.Typ(), Off: 0}
 	values, ok := s.f.NamedValues[loc]
 	if !ok {
 		s.f.Names = append(s.f.Names, loc)
@@ -960,10 +1123,10 @@ var opToSSA = map[opAndType]ssa.Op{
 	// opAndType{OEQ, types.Map}:       ssa.OpEqPtr,
 	// opAndType{OEQ, types.Chan}:      ssa.OpEqPtr,
 	// opAndType{OEQ, types.Ptr64}:     ssa.OpEqPtr,
-	opAndType{OEQ, types.Uintptr}: ssa.OpEqPtr,
-	// opAndType{OEQ, types.Unsafeptr}: ssa.OpEqPtr,
-	opAndType{OEQ, types.Float64}: ssa.OpEq64F,
-	opAndType{OEQ, types.Float32}: ssa.OpEq32F,
+	opAndType{OEQ, types.Uintptr}:       ssa.OpEqPtr,
+	opAndType{OEQ, types.UnsafePointer}: ssa.OpEqPtr,
+	opAndType{OEQ, types.Float64}:       ssa.OpEq64F,
+	opAndType{OEQ, types.Float32}:       ssa.OpEq32F,
 
 	opAndType{ONE, types.Bool}:   ssa.OpNeq8,
 	opAndType{ONE, types.Int8}:   ssa.OpNeq8,
@@ -980,10 +1143,10 @@ var opToSSA = map[opAndType]ssa.Op{
 	// opAndType{ONE, types.Map}:       ssa.OpNeqPtr,
 	// opAndType{ONE, types.Chan}:      ssa.OpNeqPtr,
 	// opAndType{ONE, types.Ptr64}:     ssa.OpNeqPtr,
-	opAndType{ONE, types.Uintptr}: ssa.OpNeqPtr,
-	// opAndType{ONE, types.Unsafeptr}: ssa.OpNeqPtr,
-	opAndType{ONE, types.Float64}: ssa.OpNeq64F,
-	opAndType{ONE, types.Float32}: ssa.OpNeq32F,
+	opAndType{ONE, types.Uintptr}:       ssa.OpNeqPtr,
+	opAndType{ONE, types.UnsafePointer}: ssa.OpNeqPtr,
+	opAndType{ONE, types.Float64}:       ssa.OpNeq64F,
+	opAndType{ONE, types.Float32}:       ssa.OpNeq32F,
 
 	opAndType{OLT, types.Int8}:    ssa.OpLess8,
 	opAndType{OLT, types.Uint8}:   ssa.OpLess8U,
@@ -1037,14 +1200,84 @@ var opToSSA = map[opAndType]ssa.Op{
 	opAndType{OSQRT, types.Float64}: ssa.OpSqrt,
 }
 
+// tokenToOp maps the Go binary operators to their node ops.
+var tokenToOp = map[token.Token]NodeOp{
+	token.ADD:     OADD,
+	token.SUB:     OSUB,
+	token.MUL:     OMUL,
+	token.QUO:     ODIV,
+	token.REM:     OMOD,
+	token.AND:     OAND,
+	token.OR:      OOR,
+	token.XOR:     OXOR,
+	token.AND_NOT: OANDNOT,
+	token.SHL:     OLSH,
+	token.SHR:     ORSH,
+	token.EQL:     OEQ,
+	token.NEQ:     ONE,
+	token.LSS:     OLT,
+	token.LEQ:     OLE,
+	token.GTR:     OGT,
+	token.GEQ:     OGE,
+}
+
+var unaryTokenToOp = map[token.Token]NodeOp{
+	token.ADD: OPLUS,
+	token.SUB: OMINUS,
+	token.XOR: OCOM,
+	token.NOT: ONOT,
+}
+
+// isComparison reports whether op is one of the comparison ops.
+func isComparison(op NodeOp) bool {
+	switch op {
+	case OEQ, ONE, OLT, OLE, OGT, OGE:
+		return true
+	}
+	return false
+}
+
+// concreteEtype returns the basic kind of t with the machine dependent
+// kinds (int, uint and uintptr) replaced by their sized equivalents.
+// Pointer shaped types (pointers, maps, chans and funcs) are all
+// reported as types.UnsafePointer.
+func (s *state) concreteEtype(t *Type) types.BasicKind {
+	if t.IsPtr() {
+		return types.UnsafePointer
+	}
+	basic := t.Basic()
+	if basic == nil {
+		s.Fatalf("type %v is not a basic type", t)
+		return types.Invalid
+	}
+	switch e := basic.Kind(); e {
+	default:
+		return e
+	case types.Int:
+		if s.config.IntSize == 8 {
+			return types.Int64
+		}
+		return types.Int32
+	case types.Uint:
+		if s.config.IntSize == 8 {
+			return types.Uint64
+		}
+		return types.Uint32
+	case types.Uintptr:
+		if s.config.PtrSize == 8 {
+			return types.Uint64
+		}
+		return types.Uint32
+	}
+}
+
 func (s *state) ssaOp(op NodeOp, t *Type) ssa.Op {
-	/*etype := s.concreteEtype(t)
+	etype := s.concreteEtype(t)
 	x, ok := opToSSA[opAndType{op, etype}]
 	if !ok {
-		//s.Unimplementedf("unhandled binary op %s %s", opnames[op], Econv(int(etype), 0))
+		s.Unimplementedf("unhandled binary op %v %v", op, etype)
 	}
-	return x*/
-	return opToSSA[opAndType{}]
+	return x
 }
 
 func floatForComplex(t *Type) *Type {
@@ -1196,24 +1429,39 @@ var shiftOpToSSA = map[opAndTwoTypes]ssa.Op{
 }
 
 func (s *state) ssaShiftOp(op NodeOp, t *Type, u *Type) ssa.Op {
-	return opToSSA[opAndType{}]
-	/*etype1 := s.concreteEtype(t)
-	etype2 := s.concreteEtype(u)
+	etype1 := s.concreteEtype(t)
+	etype2 := unsignedKind(s.concreteEtype(u))
 	x, ok := shiftOpToSSA[opAndTwoTypes{op, etype1, etype2}]
 	if !ok {
-		//s.Unimplementedf("unhandled shift op %s etype=%s/%s", opnames[op], Econv(int(etype1), 0), Econv(int(etype2), 0))
+		s.Unimplementedf("unhandled shift op %v etype=%v/%v", op, etype1, etype2)
+	}
+	return x
+}
+
+// unsignedKind returns the unsigned kind with the same width as the
+// integer kind k. Shift counts are looked up by their unsigned kind,
+// a signed count is assumed to be non-negative.
+func unsignedKind(k types.BasicKind) types.BasicKind {
+	switch k {
+	case types.Int8:
+		return types.Uint8
+	case types.Int16:
+		return types.Uint16
+	case types.Int32:
+		return types.Uint32
+	case types.Int64:
+		return types.Uint64
 	}
-	return x*/
+	return k
 }
 
 func (s *state) ssaRotateOp(op NodeOp, t *Type) ssa.Op {
-	return opToSSA[opAndType{}]
-	/*etype1 := s.concreteEtype(t)
+	etype1 := s.concreteEtype(t)
 	x, ok := opToSSA[opAndType{op, etype1}]
 	if !ok {
-		//s.Unimplementedf("unhandled rotate op %s etype=%s", opnames[op], Econv(int(etype1), 0))
+		s.Unimplementedf("unhandled rotate op %v etype=%v", op, etype1)
 	}
-	return x*/
+	return x
 }
 
 func (s *state) ssaVar(n *Node) ssaVar {
@@ -1226,8 +1474,7 @@ func (s *state) ssaVar(n *Node) ssaVar {
 	// typeObject.
 	// fn.Defs
 
-	vars := getVars(s.ctx, s.fnDecl, s.fnType)
-	for _, v := range vars {
+	for _, v := range s.fnVars {
 		if v.Name() == n.Name() {
 			return v
 		}
@@ -1243,112 +1490,604 @@ func (s *state) expr(n *Node) *ssa.Value {
 	//s.stmtList(n.Ninit)
 	ctx := s.ctx
 
+	if e, ok := n.node.(ast.Expr); ok {
+		// constant expressions, including named constants and
+		// true and false, are folded by the type checker
+		if tv := ctx.fn.Types[e]; tv.Value != nil {
+			return s.constVal(tv.Value, n.Typ().(*Type))
+		}
+	}
+
 	switch expr := n.node.(type) {
 	case *ast.Ident:
+		if s.addrtaken[ctx.fn.ObjectOf(expr)] {
+			addr := s.addr(n, false)
+			return s.load(n.Typ().(*Type), addr)
+		}
 		if canSSA(n) {
 			ssaVar := s.ssaVar(n)
 			return s.variable(ssaVar, n.Typ())
 		}
 		panic(fmt.Sprintf("unimplementedf for expr: %#v", expr))
-		// addr := s.addr(n, false)
-		// return s.newValue2(ssa.OpLoad, n.Type, addr, s.mem())
+	case *ast.StarExpr:
+		addr := s.addr(n, s.pragma&noNilCheck != 0)
+		return s.load(n.Typ().(*Type), addr)
+	case *ast.IndexExpr:
+		if t := s.exprType(expr.X); t.IsString() {
+			a := s.expr(ExprNode(expr.X, s.ctx))
+			i := s.expr(ExprNode(expr.Index, s.ctx))
+			i = s.conv(i, s.exprType(expr.Index), Typ[types.Int])
+			if s.pragma&noBounds == 0 {
+				len := s.newValue1(ssa.OpStringLen, Typ[types.Int], a)
+				s.boundsCheck(i, len)
+			}
+			ptrtyp := Typ[types.Uint8].PtrTo()
+			ptr := s.newValue1(ssa.OpStringPtr, ptrtyp, a)
+			ptr = s.newValue2(ssa.OpAddPtr, ptrtyp, ptr, i)
+			return s.load(Typ[types.Uint8], ptr)
+		}
+		addr := s.addr(n, s.pragma&noNilCheck != 0)
+		return s.load(n.Typ().(*Type), addr)
+	case *ast.SliceExpr:
+		t := s.exprType(expr.X)
+		var v *ssa.Value
+		if t.IsArray() {
+			// slicing an array slices its address
+			v = s.addr(ExprNode(expr.X, s.ctx), s.pragma&noNilCheck != 0)
+			t = t.PtrTo().(*Type)
+		} else {
+			v = s.expr(ExprNode(expr.X, s.ctx))
+		}
+		var i, j, k *ssa.Value
+		if expr.Low != nil {
+			i = s.expr(ExprNode(expr.Low, s.ctx))
+			i = s.conv(i, s.exprType(expr.Low), Typ[types.Int])
+		}
+		if expr.High != nil {
+			j = s.expr(ExprNode(expr.High, s.ctx))
+			j = s.conv(j, s.exprType(expr.High), Typ[types.Int])
+		}
+		if expr.Max != nil {
+			k = s.expr(ExprNode(expr.Max, s.ctx))
+			k = s.conv(k, s.exprType(expr.Max), Typ[types.Int])
+		}
+		p, l, c := s.slice(t, v, i, j, k)
+		if t.IsString() {
+			return s.newValue2(ssa.OpStringMake, n.Typ(), p, l)
+		}
+		return s.newValue3(ssa.OpSliceMake, n.Typ(), p, l, c)
+	case *ast.SelectorExpr:
+		sel := ctx.fn.Selections[expr]
+		if sel == nil || sel.Kind() != types.FieldVal {
+			panic(fmt.Sprintf("unimplementedf selector: %v", expr))
+		}
+		if s.inSSA(expr) {
+			// select the field from the struct value
+			v := s.expr(ExprNode(expr.X, s.ctx))
+			t := s.exprType(expr.X)
+			for _, i := range sel.Index() {
+				ft := t.FieldType(i).(*Type)
+				v = s.newValue1I(ssa.OpStructSelect, ft, int64(i), v)
+				t = ft
+			}
+			return v
+		}
+		addr := s.addr(n, s.pragma&noNilCheck != 0)
+		return s.load(n.Typ().(*Type), addr)
 	case *ast.BasicLit:
-		typeAndValue := ctx.fn.Types[expr]
-		// t := typeAndValue.Type
-		v := typeAndValue.Value
-
-		switch v.Kind() {
-		case constant.Int:
-			i, ok := constant.Int64Val(v)
-			if !ok {
-				panic("internal error")
+		return s.constVal(ctx.fn.Types[expr].Value, n.Typ().(*Type))
+	case *ast.BinaryExpr:
+		op, ok := tokenToOp[expr.Op]
+		if !ok {
+			panic(fmt.Sprintf("unimplementedf *ast.BinaryExpr: %v", expr.Op))
+		}
+		if isComparison(op) {
+			// the operands have the same type unless one is nil
+			t := s.exprType(expr.X)
+			if ctx.fn.Types[expr.X].IsNil() {
+				t = s.exprType(expr.Y)
+			} else if (t.IsSlice() || t.IsInterface()) && !ctx.fn.Types[expr.Y].IsNil() {
+				s.Unimplementedf("comparison of %v other than with nil", t)
 			}
-			switch n.Typ().Size() {
-			case 1:
-				return s.constInt8(n.Typ(), int8(i))
-			case 2:
-				return s.constInt16(n.Typ(), int16(i))
-			case 4:
-				return s.constInt32(n.Typ(), int32(i))
-			case 8:
-				return s.constInt64(n.Typ(), i)
+			a := s.operand(expr.X, t)
+			b := s.operand(expr.Y, t)
+			return s.binop(op, t, a, b)
+		}
+		if op == OLSH || op == ORSH {
+			a := s.expr(ExprNode(expr.X, s.ctx))
+			b := s.expr(ExprNode(expr.Y, s.ctx))
+			return s.shift(op, s.exprType(expr), s.exprType(expr.Y), a, b)
+		}
+		if v := s.rotate(expr); v != nil {
+			return v
+		}
+		a := s.expr(ExprNode(expr.X, s.ctx))
+		b := s.expr(ExprNode(expr.Y, s.ctx))
+		return s.binop(op, s.exprType(expr), a, b)
+	case *ast.CallExpr:
+		if isPhiCall(ctx.fn, expr) {
+			// assignStmt and varSpec convert the phis assigned to
+			// variables, the phi has the type of the variable
+			s.Errorf("phi must be assigned to a variable")
+		}
+		if isIntrinsicCall(ctx.fn, expr) {
+			return s.intrinsic(expr)
+		}
+		if fn, ok := unparen(expr.Fun).(*ast.Ident); ok {
+			if _, ok := ctx.fn.Uses[fn].(*types.Builtin); ok {
+				return s.builtinCall(fn.Name, expr)
+			}
+		}
+		if ctx.fn.Types[expr.Fun].IsType() && len(expr.Args) == 1 {
+			x := s.expr(ExprNode(expr.Args[0], s.ctx))
+			return s.conv(x, s.exprType(expr.Args[0]), s.exprType(expr))
+		}
+		panic(fmt.Sprintf("call expr not implemented: %#v", expr))
+	case *ast.UnaryExpr:
+		if expr.Op == token.AND {
+			return s.addr(ExprNode(expr.X, s.ctx), false)
+		}
+		op, ok := unaryTokenToOp[expr.Op]
+		if !ok {
+			panic(fmt.Sprintf("unimplementedf *ast.UnaryExpr: %v", expr.Op))
+		}
+		a := s.expr(ExprNode(expr.X, s.ctx))
+		if op == OPLUS {
+			return a
+		}
+		t := s.exprType(expr)
+		if t.IsComplex() {
+			// negate the real and imaginary parts
+			pt := floatForComplex(t)
+			negop := s.ssaOp(op, pt)
+			return s.newValue2(ssa.OpComplexMake, t,
+				s.newValue1(negop, pt, s.newValue1(ssa.OpComplexReal, pt, a)),
+				s.newValue1(negop, pt, s.newValue1(ssa.OpComplexImag, pt, a)))
+		}
+		return s.newValue1(s.ssaOp(op, t), t, a)
+	case *ast.TypeAssertExpr:
+		if !isOpValue(ctx.fn.TypeOf(expr.X)) {
+			s.Unimplementedf("type assertion %v", expr)
+		}
+		// the values of an OpValue have the type of their operands
+		t := s.exprType(expr)
+		if u := s.opValueType(expr.X); u == nil || !t.Equal(u) {
+			s.Errorf("%v has type %v, not %v", expr.X, u, t)
+		}
+		return s.expr(ExprNode(expr.X, s.ctx))
+	case *ast.ParenExpr:
+		return s.expr(ExprNode(expr.X, s.ctx))
+	default:
+		panic(fmt.Sprintf("unimplemented expr: %#v", expr))
+	}
+}
+
+// binop returns the ssa value of the binary operation "a op b", where a and b
+// are both of type t. The result of a comparison is a bool.
+func (s *state) binop(op NodeOp, t *Type, a, b *ssa.Value) *ssa.Value {
+	if isComparison(op) {
+		if t.IsSlice() || t.IsInterface() {
+			return s.newValue2(s.nilCompareOp(op, t), Typ[types.Bool], a, b)
+		}
+		if t.IsComplex() {
+			return s.complexCompare(op, t, a, b)
+		}
+		return s.newValue2(s.ssaOp(op, t), Typ[types.Bool], a, b)
+	}
+	if t.IsComplex() {
+		return s.complexBinop(op, t, a, b)
+	}
+	switch op {
+	case OANDNOT:
+		// a &^ b is a & ^b
+		c := s.newValue1(s.ssaOp(OCOM, t), t, b)
+		return s.newValue2(s.ssaOp(OAND, t), t, a, c)
+	default:
+		return s.newValue2(s.ssaOp(op, t), t, a, b)
+	}
+}
+
+// nilCompareOp returns the ssa op for comparing a slice or interface of type
+// t with nil, only the pointer or itab word is compared.
+func (s *state) nilCompareOp(op NodeOp, t *Type) ssa.Op {
+	switch {
+	case t.IsSlice() && op == OEQ:
+		return ssa.OpEqSlice
+	case t.IsSlice() && op == ONE:
+		return ssa.OpNeqSlice
+	case t.IsInterface() && op == OEQ:
+		return ssa.OpEqInter
+	case t.IsInterface() && op == ONE:
+		return ssa.OpNeqInter
+	}
+	s.Fatalf("invalid comparison %v of %v", op, t)
+	return 0
+}
+
+// complexBinop returns the ssa value of "a op b" for the complex values a
+// and b of type t. Products are computed in float64 to minimize
+// cancellation error.
+func (s *state) complexBinop(op NodeOp, t *Type, a, b *ssa.Value) *ssa.Value {
+	pt := floatForComplex(t)
+	switch op {
+	case OADD, OSUB:
+		fop := s.ssaOp(op, pt)
+		return s.newValue2(ssa.OpComplexMake, t,
+			s.newValue2(fop, pt, s.newValue1(ssa.OpComplexReal, pt, a), s.newValue1(ssa.OpComplexReal, pt, b)),
+			s.newValue2(fop, pt, s.newValue1(ssa.OpComplexImag, pt, a), s.newValue1(ssa.OpComplexImag, pt, b)))
+	case OMUL:
+		wt := Typ[types.Float64]
+		areal := s.newValue1(ssa.OpComplexReal, pt, a)
+		breal := s.newValue1(ssa.OpComplexReal, pt, b)
+		aimag := s.newValue1(ssa.OpComplexImag, pt, a)
+		bimag := s.newValue1(ssa.OpComplexImag, pt, b)
+		if pt != wt { // Widen for calculation
+			areal = s.newValue1(ssa.OpCvt32Fto64F, wt, areal)
+			breal = s.newValue1(ssa.OpCvt32Fto64F, wt, breal)
+			aimag = s.newValue1(ssa.OpCvt32Fto64F, wt, aimag)
+			bimag = s.newValue1(ssa.OpCvt32Fto64F, wt, bimag)
+		}
+		// (a+bi)(c+di) = (ac-bd) + (ad+bc)i
+		xreal := s.newValue2(ssa.OpSub64F, wt, s.newValue2(ssa.OpMul64F, wt, areal, breal), s.newValue2(ssa.OpMul64F, wt, aimag, bimag))
+		ximag := s.newValue2(ssa.OpAdd64F, wt, s.newValue2(ssa.OpMul64F, wt, areal, bimag), s.newValue2(ssa.OpMul64F, wt, aimag, breal))
+		if pt != wt { // Narrow to store back
+			xreal = s.newValue1(ssa.OpCvt64Fto32F, pt, xreal)
+			ximag = s.newValue1(ssa.OpCvt64Fto32F, pt, ximag)
+		}
+		return s.newValue2(ssa.OpComplexMake, t, xreal, ximag)
+	default:
+		// complex division needs a runtime call
+		s.Unimplementedf("unhandled complex op %v", op)
+		return nil
+	}
+}
+
+// complexCompare returns the ssa value of "a op b", where op is OEQ or ONE,
+// for the complex values a and b of type t.
+func (s *state) complexCompare(op NodeOp, t *Type, a, b *ssa.Value) *ssa.Value {
+	pt := floatForComplex(t)
+	eq := s.ssaOp(OEQ, pt)
+	r := s.newValue2(eq, Typ[types.Bool], s.newValue1(ssa.OpComplexReal, pt, a), s.newValue1(ssa.OpComplexReal, pt, b))
+	i := s.newValue2(eq, Typ[types.Bool], s.newValue1(ssa.OpComplexImag, pt, a), s.newValue1(ssa.OpComplexImag, pt, b))
+	c := s.newValue2(ssa.OpAnd8, Typ[types.Bool], r, i)
+	switch op {
+	case OEQ:
+		return c
+	case ONE:
+		return s.newValue1(ssa.OpNot, Typ[types.Bool], c)
+	default:
+		s.Fatalf("ordered complex compare %v", op)
+		return nil
+	}
+}
+
+// constComplex returns the complex constant v of type t.
+func (s *state) constComplex(t *Type, v constant.Value) *ssa.Value {
+	v = constant.ToComplex(v)
+	re, _ := constant.Float64Val(constant.Real(v))
+	im, _ := constant.Float64Val(constant.Imag(v))
+	pt := floatForComplex(t)
+	switch t.Size() {
+	case 8:
+		return s.entryNewValue2(ssa.OpComplexMake, t,
+			s.constFloat32(pt, float64(float32(re)+0.0)),
+			s.constFloat32(pt, float64(float32(im)+0.0)))
+	case 16:
+		return s.entryNewValue2(ssa.OpComplexMake, t, s.constFloat64(pt, re+0.0), s.constFloat64(pt, im+0.0))
+	default:
+		s.Fatalf("bad complex size %d", t.Size())
+		return nil
+	}
+}
+
+// conv returns the ssa value of x, of type ft, converted to type tt.
+func (s *state) conv(x *ssa.Value, ft, tt *Type) *ssa.Value {
+	if types.Identical(ft.Type, tt.Type) {
+		// keep constants recognizable, e.g. a constant low slice index
+		return x
+	}
+	if ft.IsVector() || tt.IsVector() {
+		if !ft.IsVector() || !tt.IsVector() {
+			s.Unimplementedf("conversion %v -> %v", ft, tt)
+		}
+		// the bits are reinterpreted, like _mm_castpd_ps
+		return s.newValue1(ssa.OpCopy, ssaType(tt), x)
+	}
+	if types.Identical(ft.Underlying(), tt.Underlying()) {
+		// named and unnamed types with the same underlying type
+		return s.newValue1(ssa.OpCopy, tt, x)
+	}
+	if tt.IsInterface() {
+		return s.ifaceConv(x, ft, tt)
+	}
+	if (ft.IsPtr() || ft.IsUintptr()) && (tt.IsPtr() || tt.IsUintptr()) {
+		// unsafe.Pointer <-> *T and unsafe.Pointer <-> uintptr
+		return s.newValue1(ssa.OpCopy, tt, x)
+	}
+	if ft.IsInteger() && tt.IsInteger() {
+		var op ssa.Op
+		if tt.Size() == ft.Size() {
+			op = ssa.OpCopy
+		} else if tt.Size() < ft.Size() {
+			// truncation
+			switch 10*ft.Size() + tt.Size() {
+			case 21:
+				op = ssa.OpTrunc16to8
+			case 41:
+				op = ssa.OpTrunc32to8
+			case 42:
+				op = ssa.OpTrunc32to16
+			case 81:
+				op = ssa.OpTrunc64to8
+			case 82:
+				op = ssa.OpTrunc64to16
+			case 84:
+				op = ssa.OpTrunc64to32
 			default:
-				s.Fatalf("bad integer size %d", n.Typ().Size())
-				return nil
+				s.Fatalf("weird integer truncation %v -> %v", ft, tt)
 			}
-		case constant.String:
-			return s.entryNewValue0A(ssa.OpConstString, n.Typ(), constant.StringVal(v))
-		case constant.Bool:
-			return s.constBool(constant.BoolVal(v))
-		case constant.Unknown:
-			panic("unknown basic literal")
-
-		case constant.Float:
-			f, ok := constant.Float64Val(v)
-			if !ok {
-				panic("internal error")
+		} else if ft.IsSigned() {
+			// sign extension
+			switch 10*ft.Size() + tt.Size() {
+			case 12:
+				op = ssa.OpSignExt8to16
+			case 14:
+				op = ssa.OpSignExt8to32
+			case 18:
+				op = ssa.OpSignExt8to64
+			case 24:
+				op = ssa.OpSignExt16to32
+			case 28:
+				op = ssa.OpSignExt16to64
+			case 48:
+				op = ssa.OpSignExt32to64
+			default:
+				s.Fatalf("bad integer sign extension %v -> %v", ft, tt)
 			}
-
-			switch n.Typ().Size() {
-			case 4:
-				// -0.0 literals need to be treated as if they were 0.0, adding 0.0 here
-				// accomplishes this while not affecting other values.
-				return s.constFloat32(n.Typ(), float64(float32(f)+0.0))
-			case 8:
-				return s.constFloat64(n.Typ(), f+0.0)
+		} else {
+			// zero extension
+			switch 10*ft.Size() + tt.Size() {
+			case 12:
+				op = ssa.OpZeroExt8to16
+			case 14:
+				op = ssa.OpZeroExt8to32
+			case 18:
+				op = ssa.OpZeroExt8to64
+			case 24:
+				op = ssa.OpZeroExt16to32
+			case 28:
+				op = ssa.OpZeroExt16to64
+			case 48:
+				op = ssa.OpZeroExt32to64
 			default:
-				s.Fatalf("bad float size %d", n.Typ().Size())
-				return nil
+				s.Fatalf("weird integer zero extension %v -> %v", ft, tt)
 			}
-		case constant.Complex:
-			panic("complex numbers not supported")
-		default:
-			s.Unimplementedf("unhandled literal %#v", expr)
-			return nil
 		}
-	case *ast.BinaryExpr:
-		// TODO
-		switch expr.Op {
-		case token.ADD:
-			return s.entryNewValue2(ssa.OpAdd64, n.Typ(), s.expr(ExprNode(expr.X, s.ctx)), s.expr(ExprNode(expr.Y, s.ctx)))
-		case token.SUB:
-			//
-		case token.MUL:
-			//
-		case token.QUO:
-			//
-		case token.REM:
-			//
-		case token.AND:
-			//
-		case token.OR:
-			//
-		case token.XOR:
-			//
-		case token.SHL:
-			//
-		case token.SHR:
-			//
-		case token.AND_NOT:
-			//
-		case token.LAND:
-			//
-		case token.LEQ:
-			//
-		case token.LOR:
-			//
-		case token.LSS:
-			//
-		case token.GTR:
-			//
+		return s.newValue1(op, tt, x)
+	}
+	if ft.IsComplex() && tt.IsComplex() {
+		var op ssa.Op
+		if ft.Size() == tt.Size() {
+			op = ssa.OpCopy
+		} else if ft.Size() == 8 && tt.Size() == 16 {
+			op = ssa.OpCvt32Fto64F
+		} else if ft.Size() == 16 && tt.Size() == 8 {
+			op = ssa.OpCvt64Fto32F
+		} else {
+			s.Fatalf("weird complex conversion %v -> %v", ft, tt)
+		}
+		ftp := floatForComplex(ft)
+		ttp := floatForComplex(tt)
+		return s.newValue2(ssa.OpComplexMake, tt,
+			s.newValue1(op, ttp, s.newValue1(ssa.OpComplexReal, ftp, x)),
+			s.newValue1(op, ttp, s.newValue1(ssa.OpComplexImag, ftp, x)))
+	}
+	if (ft.IsInteger() || ft.IsFloat()) && (tt.IsInteger() || tt.IsFloat()) {
+		conv, ok := fpConvOpToSSA[twoTypes{s.concreteEtype(ft), s.concreteEtype(tt)}]
+		if !ok {
+			s.Fatalf("weird float conversion %v -> %v", ft, tt)
+		}
+		if conv.op2 == ssa.OpInvalid {
+			return s.uint64ToFloat(x, tt)
+		}
+		if conv.op1 == ssa.OpInvalid {
+			return s.floatToUint64(x, ft, tt)
+		}
+		if conv.op2 == ssa.OpCopy {
+			if conv.op1 == ssa.OpCopy {
+				return s.newValue1(ssa.OpCopy, tt, x)
+			}
+			return s.newValue1(conv.op1, tt, x)
+		}
+		if conv.op1 == ssa.OpCopy {
+			return s.newValue1(conv.op2, tt, x)
+		}
+		it := Typ[conv.intermediateType]
+		return s.newValue1(conv.op2, tt, s.newValue1(conv.op1, it, x))
+	}
+	s.Unimplementedf("unhandled conversion %v -> %v", ft, tt)
+	return nil
+}
+
+// ifaceConv converts x, of type ft, to the interface type tt. Only the
+// conversion of a non-empty interface to an empty one is supported, the
+// others need the runtime type of ft or a call of the runtime.
+func (s *state) ifaceConv(x *ssa.Value, ft, tt *Type) *ssa.Value {
+	if !ft.IsInterface() || tt.Underlying().(*types.Interface).NumMethods() != 0 {
+		s.Errorf("conversion %v -> %v isn't supported, only the conversion of an interface to the empty interface is", ft, tt)
+	}
+	byteptr := Typ[types.Uint8].PtrTo().(*Type)
+	itab := s.newValue1(ssa.OpITab, byteptr, x)
+	data := s.newValue1(ssa.OpIData, byteptr, x)
+
+	// The first word of the empty interface is the type, which is the
+	// second word of the itab, or nil if the itab is nil.
+	s.vars[&typVar] = itab
+	cmp := s.newValue2(ssa.OpNeqPtr, Typ[types.Bool], itab, s.zeroVal(byteptr))
+	b := s.endBlock()
+	b.Kind = ssa.BlockIf
+	b.Likely = ssa.BranchLikely
+	b.Control = cmp
+
+	// Generate code for the non-nil itab case.
+	nn := s.f.NewBlock(ssa.BlockPlain)
+	b.AddEdgeTo(nn)
+	s.startBlock(nn)
+	off := s.newValue1I(ssa.OpOffPtr, byteptr.PtrTo(), StdSizes().WordSize, itab)
+	s.vars[&typVar] = s.load(byteptr, off)
+	s.endBlock()
+
+	// All done.
+	merge := s.f.NewBlock(ssa.BlockPlain)
+	b.AddEdgeTo(merge)
+	nn.AddEdgeTo(merge)
+	s.startBlock(merge)
+	typ := s.variable(&typVar, byteptr)
+	delete(s.vars, &typVar)
+	return s.newValue2(ssa.OpIMake, tt, typ, data)
+}
+
+// uint64ToFloat converts the uint64 x to the float type tt. There is no
+// unsigned conversion instruction, so when the top bit of x is set x is
+// halved (keeping the low bit so the result rounds correctly), converted
+// as a signed integer and doubled. This is done without branches:
+//
+//	s := x >> 63
+//	f := float(x>>s | x&s)
+//	result := f + f*float(s)
+func (s *state) uint64ToFloat(x *ssa.Value, tt *Type) *ssa.Value {
+	u64 := Typ[types.Uint64]
+	cvt, add, mul := ssa.OpCvt64to64F, ssa.OpAdd64F, ssa.OpMul64F
+	if tt.Size() == 4 {
+		cvt, add, mul = ssa.OpCvt64to32F, ssa.OpAdd32F, ssa.OpMul32F
+	}
+	top := s.newValue2(ssa.OpRsh64Ux64, u64, x, s.constInt64(u64, 63))
+	y := s.newValue2(ssa.OpRsh64Ux64, u64, x, top)
+	y = s.newValue2(ssa.OpOr64, u64, y, s.newValue2(ssa.OpAnd64, u64, x, top))
+	f := s.newValue1(cvt, tt, y)
+	g := s.newValue2(mul, tt, f, s.newValue1(cvt, tt, top))
+	return s.newValue2(add, tt, f, g)
+}
+
+// floatToUint64 converts the float x, of type ft, to the uint64 type tt.
+// Values below 1<<63 convert directly as signed integers, larger values
+// are converted after subtracting 1<<63 and have the top bit set again.
+// A signed conversion of a value that's out of range gives 1<<63, so
+// the sign of the direct conversion selects the result:
+//
+//	a := int64(x)
+//	b := int64(x - 1<<63) ^ 1<<63
+//	result := a | b&(a>>63)
+func (s *state) floatToUint64(x *ssa.Value, ft, tt *Type) *ssa.Value {
+	i64 := Typ[types.Int64]
+	cvt, sub := ssa.OpCvt64Fto64, ssa.OpSub64F
+	twoToThe63 := s.constFloat64(ft, 1<<63)
+	if ft.Size() == 4 {
+		cvt, sub = ssa.OpCvt32Fto64, ssa.OpSub32F
+		twoToThe63 = s.constFloat32(ft, 1<<63)
+	}
+	a := s.newValue1(cvt, i64, x)
+	b := s.newValue1(cvt, i64, s.newValue2(sub, ft, x, twoToThe63))
+	b = s.newValue2(ssa.OpXor64, i64, b, s.constInt64(i64, -1<<63))
+	mask := s.newValue2(ssa.OpRsh64x64, i64, a, s.constInt64(Typ[types.Uint64], 63))
+	r := s.newValue2(ssa.OpOr64, i64, a, s.newValue2(ssa.OpAnd64, i64, b, mask))
+	return s.newValue1(ssa.OpCopy, tt, r)
+}
+
+// shift returns the ssa value of "a op b", where op is OLSH or ORSH,
+// a is of type t and the shift count b is of type u. Counts greater
+// than or equal to the width of t follow the Go semantics, the
+// backend lowers them with a carry mask. A negative count panics in Go,
+// the runtime has no function for that panic, so a count of a signed
+// type must be a constant, which the type checker checks isn't negative.
+func (s *state) shift(op NodeOp, t, u *Type, a, b *ssa.Value) *ssa.Value {
+	if u.IsSigned() && !isConstInt(b) {
+		s.Errorf("shift count of signed type %v must be a constant", u)
+	}
+	return s.newValue2(s.ssaShiftOp(op, t, u), t, a, b)
+}
+
+// isConstInt reports whether v is an integer constant.
+func isConstInt(v *ssa.Value) bool {
+	switch v.Op {
+	case ssa.OpConst8, ssa.OpConst16, ssa.OpConst32, ssa.OpConst64:
+		return true
+	}
+	return false
+}
+
+// rotate matches the rotate idiom "x<<k | x>>(w-k)", with the operands of
+// | in either order or with ^ in place of |, where x is an unsigned
+// variable of width w and k is a constant. It returns the left rotate of
+// x by k, or nil if expr isn't a rotate.
+func (s *state) rotate(expr *ast.BinaryExpr) *ssa.Value {
+	if expr.Op != token.OR && expr.Op != token.XOR {
+		return nil
+	}
+	t := s.exprType(expr)
+	if !t.IsInteger() || t.IsSigned() {
+		return nil
+	}
+	lsh, ok1 := unparen(expr.X).(*ast.BinaryExpr)
+	rsh, ok2 := unparen(expr.Y).(*ast.BinaryExpr)
+	if !ok1 || !ok2 {
+		return nil
+	}
+	if lsh.Op == token.SHR {
+		lsh, rsh = rsh, lsh
+	}
+	if lsh.Op != token.SHL || rsh.Op != token.SHR {
+		return nil
+	}
+	x, ok1 := unparen(lsh.X).(*ast.Ident)
+	y, ok2 := unparen(rsh.X).(*ast.Ident)
+	if !ok1 || !ok2 || s.ctx.fn.ObjectOf(x) != s.ctx.fn.ObjectOf(y) {
+		return nil
+	}
+	l, ok1 := s.constCount(lsh.Y)
+	r, ok2 := s.constCount(rsh.Y)
+	w := uint64(t.Size() * 8)
+	if !ok1 || !ok2 || l == 0 || r == 0 || l+r != w {
+		return nil
+	}
+	v := s.expr(ExprNode(x, s.ctx))
+	return s.newValue1I(s.ssaRotateOp(OLROT, t), t, int64(l), v)
+}
+
+// constCount returns the value of the constant shift count e.
+func (s *state) constCount(e ast.Expr) (uint64, bool) {
+	tv := s.ctx.fn.Types[e]
+	if tv.Value == nil {
+		return 0, false
+	}
+	return constant.Uint64Val(constant.ToInt(tv.Value))
+}
 
+// unparen returns e with any enclosing parentheses stripped.
+func unparen(e ast.Expr) ast.Expr {
+	for {
+		p, ok := e.(*ast.ParenExpr)
+		if !ok {
+			return e
 		}
-		panic("unimplementedf *ast.BinaryExpr")
-	default:
-		panic(fmt.Sprintf("unimplemented expr: %#v", expr))
+		e = p.X
 	}
 }
 
+// exprType returns the type of the expression e.
+func (s *state) exprType(e ast.Expr) *Type {
+	return ExprNode(e, s.ctx).Typ().(*Type)
+}
+
+// operand converts the operand e, of type t, to ssa.
+// The predeclared nil is converted to the zero value of t.
+func (s *state) operand(e ast.Expr, t *Type) *ssa.Value {
+	if s.ctx.fn.Types[e].IsNil() {
+		return s.zeroVal(t)
+	}
+	return s.expr(ExprNode(e, s.ctx))
+}
+
 // condBranch evaluates the boolean expression cond and branches to yes
 // if cond is true and no if cond is false.
 // This function is intended to handle && and || better than just calling
@@ -1364,14 +2103,14 @@ func (s *state) condBranch(cond ast.Expr, yes, no *ssa.Block) {
 		case token.LAND:
 			ltrue := s.f.NewBlock(ssa.BlockPlain) // "cond.true"
 			s.condBranch(e.X, ltrue, no)
-			s.curBlock = ltrue
+			s.startBlock(ltrue)
 			s.condBranch(e.Y, yes, no)
 			return
 
 		case token.LOR:
 			lfalse := s.f.NewBlock(ssa.BlockPlain) // "cond.false"
 			s.condBranch(e.X, yes, lfalse)
-			s.curBlock = lfalse
+			s.startBlock(lfalse)
 			s.condBranch(e.Y, yes, no)
 			return
 		}
@@ -1391,53 +2130,326 @@ func (s *state) condBranch(cond ast.Expr, yes, no *ssa.Block) {
 	b.AddEdgeTo(no)
 }
 
+// minDense is the fewest cases of a switch that are range checked
+// when dense.
+const minDense = 4
+
+// switchStmt ends the current block with a multiway branch on tag to the
+// targets of cases, or to dflt if no case matches. Without a tag the cases
+// are conditions and are tried in order.
+//
+// Integer tags with constant cases are lowered to a binary search of
+// compares over the sorted cases. The ssa package has no jump table
+// block, so instead dense cases, with few gaps between the smallest and
+// largest, are guarded by a single unsigned range check that sends
+// values outside them straight to dflt.
+func (s *state) switchStmt(tag ast.Expr, cases []switchCase, dflt *ssa.Block) {
+	if tag == nil {
+		for _, c := range cases {
+			next := s.f.NewBlock(ssa.BlockPlain)
+			s.condBranch(c.expr, c.target, next)
+			s.startBlock(next)
+		}
+		b := s.endBlock()
+		b.AddEdgeTo(dflt)
+		return
+	}
+	t := s.exprType(tag)
+	v := s.expr(ExprNode(tag, s.ctx))
+	constCases := t.IsInteger()
+	for i := range cases {
+		cases[i].val = s.ctx.fn.Types[cases[i].expr].Value
+		constCases = constCases && cases[i].val != nil
+	}
+	if !constCases {
+		// compare with each case in order
+		for _, c := range cases {
+			next := s.f.NewBlock(ssa.BlockPlain)
+			cmp := s.binop(OEQ, t, v, s.operand(c.expr, t))
+			s.branchIf(cmp, c.target, next)
+			s.startBlock(next)
+		}
+		b := s.endBlock()
+		b.AddEdgeTo(dflt)
+		return
+	}
+	sort.Sort(byCaseValue(cases))
+	if n := len(cases); n >= minDense {
+		lo, hi := cases[0].val, cases[n-1].val
+		span := constant.BinaryOp(hi, token.SUB, lo)
+		if d, ok := constant.Uint64Val(span); ok && d < uint64(2*n) {
+			// uint(v-lo) <= uint(hi-lo)
+			ut := Typ[unsignedKind(s.concreteEtype(t))]
+			x := s.newValue2(s.ssaOp(OSUB, t), t, v, s.constVal(lo, t))
+			x = s.newValue1(ssa.OpCopy, ut, x)
+			cmp := s.newValue2(s.ssaOp(OLE, ut), Typ[types.Bool], x, s.constVal(span, ut))
+			in := s.f.NewBlock(ssa.BlockPlain)
+			s.branchIf(cmp, in, dflt)
+			s.startBlock(in)
+		}
+	}
+	s.switchSearch(v, t, cases, dflt)
+}
+
+// switchSearch ends the current block with a binary search for the value
+// v, of type t, in the sorted constant cases. Short runs of cases are
+// compared one by one.
+func (s *state) switchSearch(v *ssa.Value, t *Type, cases []switchCase, dflt *ssa.Block) {
+	if len(cases) <= 3 {
+		for i, c := range cases {
+			next := dflt
+			if i < len(cases)-1 {
+				next = s.f.NewBlock(ssa.BlockPlain)
+			}
+			cmp := s.newValue2(s.ssaOp(OEQ, t), Typ[types.Bool], v, s.constVal(c.val, t))
+			s.branchIf(cmp, c.target, next)
+			if next != dflt {
+				s.startBlock(next)
+			}
+		}
+		if len(cases) == 0 {
+			b := s.endBlock()
+			b.AddEdgeTo(dflt)
+		}
+		return
+	}
+	half := len(cases) / 2
+	lo := s.f.NewBlock(ssa.BlockPlain)
+	hi := s.f.NewBlock(ssa.BlockPlain)
+	cmp := s.newValue2(s.ssaOp(OLT, t), Typ[types.Bool], v, s.constVal(cases[half].val, t))
+	s.branchIf(cmp, lo, hi)
+	s.startBlock(lo)
+	s.switchSearch(v, t, cases[:half], dflt)
+	s.startBlock(hi)
+	s.switchSearch(v, t, cases[half:], dflt)
+}
+
+// branchIf ends the current block with a branch to yes if c is true and
+// to no otherwise.
+func (s *state) branchIf(c *ssa.Value, yes, no *ssa.Block) {
+	b := s.endBlock()
+	b.Kind = ssa.BlockIf
+	b.Control = c
+	b.Likely = ssa.BranchUnknown
+	b.AddEdgeTo(yes)
+	b.AddEdgeTo(no)
+}
+
+// byCaseValue sorts switch cases by their constant values.
+type byCaseValue []switchCase
+
+func (c byCaseValue) Len() int           { return len(c) }
+func (c byCaseValue) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
+func (c byCaseValue) Less(i, j int) bool { return constant.Compare(c[i].val, token.LSS, c[j].val) }
+
 //assign(left *Node, right *ssa.Value, wb bool) {
 func (s *state) assignStmt(stmt *ast.AssignStmt) {
-	if len(stmt.Lhs) > 1 || len(stmt.Rhs) > 1 {
-		s.Errorf("Multivalue assignments not allowed")
+	if token.ADD_ASSIGN <= stmt.Tok && stmt.Tok <= token.AND_NOT_ASSIGN {
+		// the op= tokens are in the same order as the ops
+		op, ok := tokenToOp[stmt.Tok+(token.ADD-token.ADD_ASSIGN)]
+		if !ok {
+			panic(fmt.Sprintf("unimplementedf assignment: %v", stmt.Tok))
+		}
+		s.opAssign(op, stmt.Lhs[0], stmt.Rhs[0])
 		return
 	}
-	if len(stmt.Lhs) == 0 || len(stmt.Rhs) == 0 {
+	if stmt.Tok != token.ASSIGN && stmt.Tok != token.DEFINE {
 		panic("internal error")
 	}
-	leftExpr := stmt.Lhs[0]
-	rightExpr := stmt.Rhs[0]
-	leftIdent, ok := leftExpr.(*ast.Ident)
-	if !ok {
-		s.Errorf("expected ident")
+	if len(stmt.Lhs) != len(stmt.Rhs) {
+		s.Errorf("Multivalue assignments must have one value per variable")
 		return
 	}
-	rightValue := s.expr(&Node{node: rightExpr, ctx: s.ctx, class: PAUTO})
-	if stmt.Tok == token.DEFINE {
-		leftNode := &Node{node: leftIdent, ctx: s.ctx, class: PAUTO}
-		leftObj := s.fnInfo.ObjectOf(leftIdent)
-		leftVar := &ssaLocal{obj: leftObj, ctx: s.ctx}
-		s.addNamedValue(leftNode, rightValue)
-		if canSSA(leftNode) {
-			// Update variable assignment.
-			s.vars[leftVar] = rightValue
-			s.addNamedValue(leftNode, rightValue)
-			return
-		} else {
-			panic("can't ssa node")
-		}
-	} else if stmt.Tok == token.ASSIGN {
-		leftNode := &Node{node: leftIdent, ctx: s.ctx, class: PAUTO}
-		leftObj := s.fnInfo.ObjectOf(leftIdent)
-		leftVar := &ssaLocal{obj: leftObj, ctx: s.ctx}
-		s.addNamedValue(leftNode, rightValue)
-		if canSSA(leftNode) {
-			// Update variable assignment.
-			s.vars[leftVar] = rightValue
-			s.addNamedValue(leftNode, rightValue)
+	// Evaluate every right hand side before binding any of the
+	// left hand sides, so that "a, b = b, a" swaps a and b.
+	values := make([]*ssa.Value, len(stmt.Rhs))
+	for i, rightExpr := range stmt.Rhs {
+		id, _ := unparen(stmt.Lhs[i]).(*ast.Ident)
+		if call := s.phiCall(rightExpr); call != nil && !isBlankIdent(id) {
+			values[i] = s.phi(call, s.exprType(stmt.Lhs[i]))
+			continue
+		}
+		values[i] = s.expr(&Node{node: rightExpr, ctx: s.ctx, class: PAUTO})
+	}
+	for i, leftExpr := range stmt.Lhs {
+		s.assign(leftExpr, values[i])
+	}
+}
+
+// varSpec binds the variables declared by spec to their initial values,
+// or to the zero value if there are none.
+func (s *state) varSpec(spec *ast.ValueSpec) {
+	if len(spec.Values) != 0 {
+		if len(spec.Values) != len(spec.Names) {
+			s.Errorf("Multivalue declarations must have one value per variable")
 			return
-		} else {
-			panic("can't ssa node")
 		}
+		// as in assignStmt every value is evaluated first
+		values := make([]*ssa.Value, len(spec.Values))
+		for i, value := range spec.Values {
+			t := s.exprType(spec.Names[i])
+			if call := s.phiCall(value); call != nil && !isBlankIdent(spec.Names[i]) {
+				values[i] = s.phi(call, t)
+				continue
+			}
+			values[i] = s.operand(value, t)
+		}
+		for i, name := range spec.Names {
+			s.assign(name, values[i])
+		}
+		return
+	}
+	for _, name := range spec.Names {
+		if isBlankIdent(name) {
+			continue
+		}
+		t := s.exprType(name)
+		if s.inSSA(name) {
+			s.assign(name, s.zeroVal(t))
+			continue
+		}
+		addr := s.addr(ExprNode(name, s.ctx), false)
+		s.vars[&memVar] = s.newValue2I(ssa.OpZero, ssa.TypeMem, t.Size(), addr, s.mem())
+	}
+}
+
+// opAssign lowers "lhs op= rhs", a nil rhs is the 1 of "lhs++" and "lhs--".
+// A left hand side in memory has its address computed once.
+func (s *state) opAssign(op NodeOp, lhs, rhs ast.Expr) {
+	t := s.exprType(lhs)
+	var addr *ssa.Value
+	if !s.inSSA(lhs) {
+		addr = s.addr(ExprNode(lhs, s.ctx), s.pragma&noNilCheck != 0)
+	}
+	var b *ssa.Value
+	var u *Type
+	if rhs == nil {
+		b, u = s.one(t), t
 	} else {
-		panic("internal error")
+		b, u = s.expr(ExprNode(rhs, s.ctx)), s.exprType(rhs)
+	}
+	var a *ssa.Value
+	if addr != nil {
+		a = s.load(t, addr)
+	} else {
+		a = s.expr(ExprNode(lhs, s.ctx))
+	}
+	var v *ssa.Value
+	if op == OLSH || op == ORSH {
+		v = s.shift(op, t, u, a, b)
+	} else {
+		v = s.binop(op, t, a, b)
+	}
+	if addr != nil {
+		s.store(t, addr, v)
+		return
+	}
+	s.assign(lhs, v)
+}
+
+// one returns the constant 1 of the numeric type t.
+func (s *state) one(t *Type) *ssa.Value {
+	return s.constVal(constant.MakeInt64(1), t)
+}
+
+// constVal returns the constant v as a value of type t.
+func (s *state) constVal(v constant.Value, t *Type) *ssa.Value {
+	switch {
+	case t.IsComplex():
+		return s.constComplex(t, v)
+	case t.IsFloat():
+		f, _ := constant.Float64Val(constant.ToFloat(v))
+		switch t.Size() {
+		case 4:
+			// -0.0 literals need to be treated as if they were 0.0, adding 0.0 here
+			// accomplishes this while not affecting other values.
+			return s.constFloat32(t, float64(float32(f)+0.0))
+		case 8:
+			return s.constFloat64(t, f+0.0)
+		default:
+			s.Fatalf("bad float size %d", t.Size())
+			return nil
+		}
+	case t.IsInteger():
+		v = constant.ToInt(v)
+		i, ok := constant.Int64Val(v)
+		if !ok {
+			// unsigned values that don't fit in an int64
+			u, _ := constant.Uint64Val(v)
+			i = int64(u)
+		}
+		switch t.Size() {
+		case 1:
+			return s.constInt8(t, int8(i))
+		case 2:
+			return s.constInt16(t, int16(i))
+		case 4:
+			return s.constInt32(t, int32(i))
+		case 8:
+			return s.constInt64(t, i)
+		default:
+			s.Fatalf("bad integer size %d", t.Size())
+			return nil
+		}
+	case t.IsString():
+		return s.entryNewValue0A(ssa.OpConstString, t, constant.StringVal(v))
+	case t.IsBoolean():
+		return s.constBool(constant.BoolVal(v))
+	default:
+		s.Unimplementedf("unhandled constant %v of type %v", v, t)
+		return nil
 	}
+}
 
+// assign binds the value v to the variable leftExpr, or stores it
+// if leftExpr is in memory.
+func (s *state) assign(leftExpr ast.Expr, v *ssa.Value) {
+	switch lhs := unparen(leftExpr).(type) {
+	case *ast.StarExpr, *ast.IndexExpr:
+		n := ExprNode(lhs, s.ctx)
+		addr := s.addr(n, s.pragma&noNilCheck != 0)
+		s.store(n.Typ().(*Type), addr, v)
+		return
+	}
+	if sel, ok := unparen(leftExpr).(*ast.SelectorExpr); ok {
+		if !s.inSSA(sel) {
+			// a field of a struct in memory or through a pointer
+			n := ExprNode(sel, s.ctx)
+			addr := s.addr(n, s.pragma&noNilCheck != 0)
+			s.store(n.Typ().(*Type), addr, v)
+			return
+		}
+		// rebuild the struct value with the new field value
+		path := s.fnInfo.Selections[sel].Index()
+		if len(path) != 1 {
+			s.Unimplementedf("assignment to promoted field %v", sel)
+			return
+		}
+		t := s.exprType(sel.X)
+		old := s.expr(ExprNode(sel.X, s.ctx))
+		s.assign(sel.X, s.structWith(t, old, path[0], v))
+		return
+	}
+	leftIdent, ok := unparen(leftExpr).(*ast.Ident)
+	if !ok {
+		s.Errorf("expected ident")
+		return
+	}
+	if leftIdent.Name == "_" {
+		return
+	}
+	leftNode := &Node{node: leftIdent, ctx: s.ctx, class: PAUTO}
+	if s.addrtaken[s.fnInfo.ObjectOf(leftIdent)] {
+		addr := s.addr(leftNode, false)
+		s.store(leftNode.Typ().(*Type), addr, v)
+		return
+	}
+	if !canSSA(leftNode) {
+		panic("can't ssa node")
+	}
+	// Update variable assignment.
+	s.vars[s.ssaVar(leftNode)] = v
+	s.addNamedValue(leftNode, v)
 }
 
 func canSSA(n ssaVar) bool {
@@ -1445,12 +2457,117 @@ func canSSA(n ssaVar) bool {
 	case PEXTERN, PPARAMOUT, PPARAMREF:
 		return false
 	}
-	return true
+	return canSSAType(n.Typ().(*Type))
+}
+
+// canSSAType reports whether variables of type t are SSA-able.
+func canSSAType(t *Type) bool {
+	if t.Size() > int64(4*StdSizes().WordSize) {
+		// 4*Widthptr is an arbitrary constant.  We want it
+		// to be at least 3*Widthptr so slices can be registerized.
+		// Too big and we'll introduce too much register pressure.
+		return false
+	}
+	switch {
+	case t.IsVector():
+		return true
+	case t.IsArray():
+		// We can't do arrays because dynamic indexing is
+		// not supported on SSA variables.
+		return false
+	case t.IsStruct():
+		if t.NumFields() > ssa.MaxStruct {
+			return false
+		}
+		for i := 0; i < t.NumFields(); i++ {
+			ft := t.FieldType(i).(*Type)
+			if ft.IsVector() {
+				// the fields would be decomposed into values of
+				// the vector type rather than ssa.TypeInt128
+				return false
+			}
+			if !canSSAType(ft) {
+				return false
+			}
+		}
+		return true
+	default:
+		return true
+	}
+}
+
+// inSSA reports whether the value of e, a variable or a field of a
+// variable, is an SSA value rather than in memory.
+func (s *state) inSSA(e ast.Expr) bool {
+	switch e := unparen(e).(type) {
+	case *ast.Ident:
+		n := ExprNode(e, s.ctx)
+		return !s.addrtaken[s.fnInfo.ObjectOf(e)] && canSSA(s.ssaVar(n))
+	case *ast.SelectorExpr:
+		sel := s.fnInfo.Selections[e]
+		return sel != nil && sel.Kind() == types.FieldVal && !sel.Indirect() &&
+			!s.exprType(e.X).IsPtr() && s.inSSA(e.X)
+	}
+	return false
+}
+
+// structWith returns the struct value x, of type t, with field i set to v.
+func (s *state) structWith(t *Type, x *ssa.Value, i int, v *ssa.Value) *ssa.Value {
+	nf := t.NumFields()
+	args := make([]*ssa.Value, nf)
+	for j := 0; j < nf; j++ {
+		if j == i {
+			args[j] = v
+		} else {
+			args[j] = s.newValue1I(ssa.OpStructSelect, t.FieldType(j), int64(j), x)
+		}
+	}
+	r := s.newValue0(ssa.StructMakeOp(nf), t)
+	r.AddArgs(args...)
+	return r
+}
+
+// ssaType returns the type of the ssa values of type t. The values of the
+// SSE vector types have type ssa.TypeInt128, which the register allocator
+// assigns to the X registers.
+func ssaType(t *Type) ssa.Type {
+	if t.IsVector() {
+		return ssa.TypeInt128
+	}
+	return t
+}
+
+// load returns the value of type t at addr.
+func (s *state) load(t *Type, addr *ssa.Value) *ssa.Value {
+	if t.IsVector() {
+		// there's no generic 16 byte load
+		return s.newValue2(ssa.OpAMD64MOVOload, ssaType(t), addr, s.mem())
+	}
+	return s.newValue2(ssa.OpLoad, t, addr, s.mem())
+}
+
+// store stores v, of type t, at addr.
+func (s *state) store(t *Type, addr, v *ssa.Value) {
+	if t.IsVector() {
+		// there's no generic 16 byte store
+		s.vars[&memVar] = s.newValue3(ssa.OpAMD64MOVOstore, ssa.TypeMem, addr, v, s.mem())
+		return
+	}
+	s.vars[&memVar] = s.newValue3I(ssa.OpStore, ssa.TypeMem, t.Size(), addr, v, s.mem())
 }
 
 // zeroVal returns the zero value for type t.
 func (s *state) zeroVal(t *Type) *ssa.Value {
 	switch {
+	case t.IsVector():
+		return s.entryNewValue0I(ssa.OpAMD64MOVOconst, ssaType(t), 0)
+	case t.IsStruct():
+		n := t.NumFields()
+		v := s.entryNewValue0(ssa.StructMakeOp(n), t)
+		for i := 0; i < n; i++ {
+			v.AddArg(s.zeroVal(t.FieldType(i).(*Type)))
+		}
+		return v
 	case t.IsInteger():
 		switch t.Size() {
 		case 1:
@@ -1503,7 +2620,7 @@ func (s *state) zeroVal(t *Type) *ssa.Value {
 // lookupSymbol is used to retrieve the symbol (Extern, Arg or Auto) used for a particular node.
 // This improves the effectiveness of cse by using the same Aux values for the
 // same symbols.
-func (s *state) lookupSymbol(n *Node, sym interface{}) interface{} {
+func (s *state) lookupSymbol(n ssaVar, sym interface{}) interface{} {
 	switch sym.(type) {
 	default:
 		s.Fatalf("sym %v is of uknown type %T", sym, sym)
@@ -1511,13 +2628,12 @@ func (s *state) lookupSymbol(n *Node, sym interface{}) interface{} {
 		// these are the only valid types
 	}
 
-	// if lsym, ok := s.varsyms[n]; ok {
-	// 	return lsym
-	// } else {
-	// 	s.varsyms[n] = sym
-	// 	return sym
-	// }
-	return nil
+	if lsym, ok := s.varsyms[n]; ok {
+		return lsym
+	} else {
+		s.varsyms[n] = sym
+		return sym
+	}
 }
 
 // addr converts the address of the expression n to SSA, adds it to s and returns the SSA result.
@@ -1525,114 +2641,317 @@ func (s *state) lookupSymbol(n *Node, sym interface{}) interface{} {
 // If bounded is true then this address does not require a nil check for its operand
 // even if that would otherwise be implied.
 func (s *state) addr(n *Node, bounded bool) *ssa.Value {
+	t := n.Typ().PtrTo()
+	switch node := n.node.(type) {
+	case *ast.Ident:
+		v := s.ssaVar(n)
+		switch v.Class() {
+		case PPARAM:
+			// parameter or result slot
+			aux := s.lookupSymbol(v, &ssa.ArgSymbol{Typ: v.Typ(), Node: v})
+			return s.entryNewValue1A(ssa.OpAddr, t, aux, s.sp)
+		case PAUTO:
+			// We need to regenerate the address of autos
+			// at every use.  This prevents LEA instructions
+			// from occurring before the corresponding VarDef
+			// op and confusing the liveness analysis into thinking
+			// the variable is live at function entry.
+			aux := s.lookupSymbol(v, &ssa.AutoSymbol{Typ: v.Typ(), Node: v})
+			return s.newValue1A(ssa.OpAddr, t, aux, s.sp)
+		default:
+			s.Unimplementedf("variable address class %v not implemented", v.Class())
+			return nil
+		}
+	case *ast.StarExpr:
+		p := s.expr(ExprNode(node.X, s.ctx))
+		if !bounded {
+			s.nilCheck(p)
+		}
+		return p
+	case *ast.ParenExpr:
+		return s.addr(ExprNode(node.X, s.ctx), bounded)
+	case *ast.IndexExpr:
+		t := s.exprType(node.X)
+		var a, len *ssa.Value
+		switch {
+		case t.IsSlice():
+			a = s.expr(ExprNode(node.X, s.ctx))
+		case t.IsMap():
+			s.Unimplementedf("map index not implemented")
+			return nil
+		case t.IsPtr():
+			// pointer to array
+			a = s.expr(ExprNode(node.X, s.ctx))
+			if !bounded {
+				s.nilCheck(a)
+			}
+			t = t.Elem().(*Type)
+		case t.IsArray():
+			a = s.addr(ExprNode(node.X, s.ctx), bounded)
+		default:
+			s.Unimplementedf("unhandled addr of index of %v", t)
+			return nil
+		}
+		i := s.expr(ExprNode(node.Index, s.ctx))
+		i = s.conv(i, s.exprType(node.Index), Typ[types.Int])
+		if t.IsSlice() {
+			len = s.newValue1(ssa.OpSliceLen, Typ[types.Int], a)
+			a = s.newValue1(ssa.OpSlicePtr, t.Elem().PtrTo(), a)
+		} else {
+			len = s.constInt(Typ[types.Int], t.NumElem())
+		}
+		if s.pragma&noBounds == 0 {
+			s.boundsCheck(i, len)
+		}
+		return s.newValue2(ssa.OpPtrIndex, t.Elem().PtrTo(), a, i)
+	case *ast.SelectorExpr:
+		sel := s.ctx.fn.Selections[node]
+		if sel == nil || sel.Kind() != types.FieldVal {
+			s.Unimplementedf("unhandled addr of selector %v", node)
+			return nil
+		}
+		// p is the address of the struct, t is the type of the struct
+		var p *ssa.Value
+		t := s.exprType(node.X)
+		if t.IsPtr() {
+			p = s.expr(ExprNode(node.X, s.ctx))
+			if !bounded {
+				s.nilCheck(p)
+			}
+			t = t.Elem().(*Type)
+		} else {
+			p = s.addr(ExprNode(node.X, s.ctx), bounded)
+		}
+		for _, i := range sel.Index() {
+			if t.IsPtr() {
+				// embedded pointer
+				p = s.load(t, p)
+				if !bounded {
+					s.nilCheck(p)
+				}
+				t = t.Elem().(*Type)
+			}
+			ft := t.FieldType(i).(*Type)
+			p = s.newValue1I(ssa.OpOffPtr, ft.PtrTo(), t.FieldOff(i), p)
+			t = ft
+		}
+		return p
+	default:
+		s.Unimplementedf("unhandled addr %#v", node)
+		return nil
+	}
+}
+
+// panicindex is the runtime function called when an index is out of range.
+var panicindex = &LSym{Name: "runtime·panicindex"}
+
+// boundsCheck generates bounds checking code.  Checks if 0 <= idx < len, branches to exit if not.
+// Starts a new block on return.
+func (s *state) boundsCheck(idx, len *ssa.Value) {
+	// bounds check
+	cmp := s.newValue2(ssa.OpIsInBounds, Typ[types.Bool], idx, len)
+	s.check(cmp, panicindex)
+}
+
+// panicslice is the runtime function called when slice indices are out of range.
+var panicslice = &LSym{Name: "runtime·panicslice"}
+
+// sliceBoundsCheck generates slice bounds checking code.  Checks if 0 <= idx <= len, branches to exit if not.
+// Starts a new block on return.
+func (s *state) sliceBoundsCheck(idx, len *ssa.Value) {
+	if s.pragma&noBounds != 0 {
+		return
+	}
+	// bounds check
+	cmp := s.newValue2(ssa.OpIsSliceInBounds, Typ[types.Bool], idx, len)
+	s.check(cmp, panicslice)
+}
+
+// slice computes the slice v[i:j:k] and returns ptr, len, and cap of result.
+// i,j,k may be nil, in which case they are set to their default value.
+// t is a slice, ptr to array, or string type.
+func (s *state) slice(t *Type, v, i, j, k *ssa.Value) (p, l, c *ssa.Value) {
+	var elemtype *Type
+	var ptr, len, cap *ssa.Value
+	intType := Typ[types.Int]
+	switch {
+	case t.IsSlice():
+		elemtype = t.Elem().(*Type)
+		ptr = s.newValue1(ssa.OpSlicePtr, elemtype.PtrTo(), v)
+		len = s.newValue1(ssa.OpSliceLen, intType, v)
+		cap = s.newValue1(ssa.OpSliceCap, intType, v)
+	case t.IsString():
+		elemtype = Typ[types.Uint8]
+		ptr = s.newValue1(ssa.OpStringPtr, elemtype.PtrTo(), v)
+		len = s.newValue1(ssa.OpStringLen, intType, v)
+		cap = len
+	case t.IsPtr():
+		at, ok := t.Elem().(*Type)
+		if !ok || !at.IsArray() {
+			s.Fatalf("bad ptr to array in slice %v\n", t)
+		}
+		elemtype = at.Elem().(*Type)
+		if s.pragma&noNilCheck == 0 {
+			s.nilCheck(v)
+		}
+		ptr = v
+		len = s.constInt(intType, at.NumElem())
+		cap = len
+	default:
+		s.Fatalf("bad type in slice %v\n", t)
+	}
+
+	// Set default values
+	if i == nil {
+		i = s.constInt(intType, 0)
+	}
+	if j == nil {
+		j = len
+	}
+	if k == nil {
+		k = cap
+	}
+
+	// Panic if slice indices are not in bounds.
+	s.sliceBoundsCheck(i, j)
+	if j != k {
+		s.sliceBoundsCheck(j, k)
+	}
+	if k != cap {
+		s.sliceBoundsCheck(k, cap)
+	}
+
+	// Generate the following code assuming that indexes are in bounds.
+	//
+	//	rlen = j-i
+	//	rcap = k-i
+	//	rptr = p+i*elemsize (if rcap != 0), p (if rcap == 0)
+	subOp := s.ssaOp(OSUB, intType)
+	rlen := s.newValue2(subOp, intType, j, i)
+	var rcap *ssa.Value
+	switch {
+	case t.IsString(), j == k:
+		rcap = rlen
+	default:
+		rcap = s.newValue2(subOp, intType, k, i)
+	}
+	ptrtype := elemtype.PtrTo()
+	if i.Op == ssa.OpConst64 && i.AuxInt == 0 {
+		// No pointer arithmetic necessary.
+		return ptr, rlen, rcap
+	}
+
+	// Only advance the pointer if the result has a non-zero capacity,
+	// otherwise it could point past the end of the backing store.
+	s.vars[&ptrVar] = ptr
+	cmp := s.newValue2(s.ssaOp(ONE, intType), Typ[types.Bool], rcap, s.constInt(intType, 0))
+	b := s.endBlock()
+	b.Kind = ssa.BlockIf
+	b.Likely = ssa.BranchLikely
+	b.Control = cmp
+
+	// Generate code for the non-zero capacity case.
+	nz := s.f.NewBlock(ssa.BlockPlain)
+	b.AddEdgeTo(nz)
+	s.startBlock(nz)
+	s.vars[&ptrVar] = s.newValue2(ssa.OpPtrIndex, ptrtype, ptr, i)
+	s.endBlock()
+
+	// All done.
+	merge := s.f.NewBlock(ssa.BlockPlain)
+	b.AddEdgeTo(merge)
+	nz.AddEdgeTo(merge)
+	s.startBlock(merge)
+	rptr := s.variable(&ptrVar, ptrtype)
+	delete(s.vars, &ptrVar)
+	return rptr, rlen, rcap
+}
+
+// If cmp (a bool) is false, panic using the given function.
+// Starts a new block on return.
+func (s *state) check(cmp *ssa.Value, fn *LSym) {
+	b := s.endBlock()
+	b.Kind = ssa.BlockIf
+	b.Control = cmp
+	b.Likely = ssa.BranchLikely
+	bNext := s.f.NewBlock(ssa.BlockPlain)
+	bPanic := s.f.NewBlock(ssa.BlockPlain)
+	b.AddEdgeTo(bNext)
+	b.AddEdgeTo(bPanic)
+	s.startBlock(bPanic)
+	// The panic call takes/returns memory to ensure that the right
+	// memory state is observed if the panic happens.
+	call := s.newValue1A(ssa.OpStaticCall, ssa.TypeMem, fn, s.mem())
+	s.vars[&memVar] = call
+	b = s.endBlock()
+	b.Kind = ssa.BlockExit
+	b.Control = call
+	s.startBlock(bNext)
+}
+
+// builtinCall converts the call of the builtin function name to SSA.
+func (s *state) builtinCall(name string, call *ast.CallExpr) *ssa.Value {
+	if tv := s.ctx.fn.Types[call]; tv.Value != nil && (name == "len" || name == "cap") {
+		// len and cap of arrays are constants
+		c, _ := constant.Int64Val(tv.Value)
+		return s.constInt(Typ[types.Int], c)
+	}
+	switch name {
+	case "len", "cap":
+		t := s.exprType(call.Args[0])
+		v := s.expr(ExprNode(call.Args[0], s.ctx))
+		switch {
+		case t.IsString() && name == "len":
+			return s.newValue1(ssa.OpStringLen, Typ[types.Int], v)
+		case t.IsSlice() && name == "len":
+			return s.newValue1(ssa.OpSliceLen, Typ[types.Int], v)
+		case t.IsSlice() && name == "cap":
+			return s.newValue1(ssa.OpSliceCap, Typ[types.Int], v)
+		}
+		s.Unimplementedf("%v of %v not implemented", name, t)
+	case "complex":
+		t := s.exprType(call)
+		pt := floatForComplex(t)
+		r := s.complexPart(call.Args[0], pt)
+		i := s.complexPart(call.Args[1], pt)
+		return s.newValue2(ssa.OpComplexMake, t, r, i)
+	case "real", "imag":
+		op := OREAL
+		if name == "imag" {
+			op = OIMAG
+		}
+		t := s.exprType(call.Args[0])
+		v := s.expr(ExprNode(call.Args[0], s.ctx))
+		return s.newValue1(s.ssaOp(op, t), floatForComplex(t), v)
+	default:
+		s.Unimplementedf("builtin %v not implemented", name)
+	}
 	return nil
-	// t := Ptrto(n.Type())
-	// switch n.Op() {
-	// case ONAME:
-	// 	switch n.Class() {
-	// 	case PEXTERN:
-	// 		panic("External variables are unsupported")
-	// 	case PPARAM:
-	// 		// parameter slot
-	// 		v := s.decladdrs[n]
-	// 		if v == nil {
-	// 			if flag_race != 0 && n.String() == ".fp" {
-	// 				s.Unimplementedf("race detector mishandles nodfp")
-	// 			}
-	// 			s.Fatalf("addr of undeclared ONAME %v. declared: %v", n, s.decladdrs)
-	// 		}
-	// 		return v
-	// 	case PAUTO:
-	// 		// We need to regenerate the address of autos
-	// 		// at every use.  This prevents LEA instructions
-	// 		// from occurring before the corresponding VarDef
-	// 		// op and confusing the liveness analysis into thinking
-	// 		// the variable is live at function entry.
-	// 		// TODO: I'm not sure if this really works or we're just
-	// 		// getting lucky.  We might need a real dependency edge
-	// 		// between vardef and addr ops.
-	// 		aux := &ssa.AutoSymbol{Typ: n.Type(), Node: n}
-	// 		return s.newValue1A(ssa.OpAddr, t, aux, s.sp)
-	// 	case PPARAMOUT: // Same as PAUTO -- cannot generate LEA early.
-	// 		// ensure that we reuse symbols for out parameters so
-	// 		// that cse works on their addresses
-	// 		aux := s.lookupSymbol(n, &ssa.ArgSymbol{Typ: n.Type(), Node: n})
-	// 		return s.newValue1A(ssa.OpAddr, t, aux, s.sp)
-	// 	case PAUTO | PHEAP, PPARAM | PHEAP, PPARAMOUT | PHEAP, PPARAMREF:
-	// 		return s.expr(n.Name().Heapaddr())
-	// 	default:
-	// 		s.Unimplementedf("variable address class %v not implemented", n.Class)
-	// 		return nil
-	// 	}
-	// case OINDREG:
-	// 	// indirect off a register
-	// 	// used for storing/loading arguments/returns to/from callees
-	// 	if int(n.Reg()) != Thearch.REGSP {
-	// 		s.Unimplementedf("OINDREG of non-SP register %s in addr: %v", "n.Reg", n) //obj.Rconv(int(n.Reg)), n)
-	// 		return nil
-	// 	}
-	// 	return s.entryNewValue1I(ssa.OpOffPtr, t, n.Xoffset(), s.sp)
-	// case OINDEX:
-	// 	if n.Left().Type().IsSlice() {
-	// 		a := s.expr(n.Left())
-	// 		i := s.expr(n.Right())
-	// 		i = s.extendIndex(i)
-	// 		len := s.newValue1(ssa.OpSliceLen, Types[TINT], a)
-	// 		if !n.Bounded() {
-	// 			s.boundsCheck(i, len)
-	// 		}
-	// 		p := s.newValue1(ssa.OpSlicePtr, t, a)
-	// 		return s.newValue2(ssa.OpPtrIndex, t, p, i)
-	// 	} else { // array
-	// 		a := s.addr(n.Left(), bounded)
-	// 		i := s.expr(n.Right())
-	// 		i = s.extendIndex(i)
-	// 		len := s.constInt(Types[TINT], n.Left().Type().Bound())
-	// 		if !n.Bounded() {
-	// 			s.boundsCheck(i, len)
-	// 		}
-	// 		et := n.Left().Type().Elem()
-	// 		elemType := et.(*Type)
-	// 		return s.newValue2(ssa.OpPtrIndex, Ptrto(elemType), a, i)
-	// 	}
-	// case OIND:
-	// 	p := s.expr(n.Left())
-	// 	if !bounded {
-	// 		s.nilCheck(p)
-	// 	}
-	// 	return p
-	// case ODOT:
-	// 	p := s.addr(n.Left(), bounded)
-	// 	return s.newValue2(ssa.OpAddPtr, t, p, s.constInt(Types[TINT], n.Xoffset()))
-	// case ODOTPTR:
-	// 	p := s.expr(n.Left())
-	// 	if !bounded {
-	// 		s.nilCheck(p)
-	// 	}
-	// 	return s.newValue2(ssa.OpAddPtr, t, p, s.constInt(Types[TINT], n.Xoffset()))
-	// case OCLOSUREVAR:
-	// 	return s.newValue2(ssa.OpAddPtr, t,
-	// 		s.entryNewValue0(ssa.OpGetClosurePtr, Ptrto(Types[TUINT8])),
-	// 		s.constInt(Types[TINT], n.Xoffset()))
-	// case OPARAM:
-	// 	p := n.Left()
-	// 	if p.Op() != ONAME || !(p.Class() == PPARAM|PHEAP || p.Class() == PPARAMOUT|PHEAP) {
-	// 		panic("OPARAM not of ONAME,{PPARAM,PPARAMOUT}|PHEAP")
-	// 	}
-
-	// 	// Recover original offset to address passed-in param value.
-	// 	original_p := *p
-	// 	//original_p.Xoffset() = n.Xoffset()
-	// 	aux := &ssa.ArgSymbol{Typ: n.Type(), Node: &original_p}
-	// 	return s.entryNewValue1A(ssa.OpAddr, t, aux, s.sp)
-	// case OCONVNOP:
-	// 	addr := s.addr(n.Left(), bounded)
-	// 	return s.newValue1(ssa.OpCopy, t, addr) // ensure that addr has the right type
-
-	// default:
-	// 	s.Unimplementedf("unhandled addr %v", Oconv(int(n.Op()), 0))
-	// 	return nil
-	// }
+}
+
+// complexPart returns the argument e of complex() as a value of the
+// float type pt, constant arguments take the type of the result.
+func (s *state) complexPart(e ast.Expr, pt *Type) *ssa.Value {
+	if tv := s.ctx.fn.Types[e]; tv.Value != nil {
+		f, _ := constant.Float64Val(constant.ToFloat(tv.Value))
+		if pt.Size() == 4 {
+			return s.constFloat32(pt, float64(float32(f)+0.0))
+		}
+		return s.constFloat64(pt, f+0.0)
+	}
+	return s.expr(ExprNode(e, s.ctx))
+}
+
+// nilCheck generates nil pointer checking code.
+// Starts a new block on return.
+func (s *state) nilCheck(ptr *ssa.Value) {
+	chk := s.newValue2(ssa.OpNilCheck, ssa.TypeVoid, ptr, s.mem())
+	b := s.endBlock()
+	b.Kind = ssa.BlockCheck
+	b.Control = chk
+	bNext := s.f.NewBlock(ssa.BlockPlain)
+	b.AddEdgeTo(bNext)
+	s.startBlock(bNext)
 }
 
 // checkGoto checks that a goto from from to to does not
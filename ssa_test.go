@@ -0,0 +1,1213 @@
+package ssair
+
+import (
+	"go/types"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bjwbell/ssa"
+)
+
+// countOps returns the number of values of each op in f.
+func countOps(f *ssa.Func) map[ssa.Op]int {
+	ops := map[ssa.Op]int{}
+	for _, b := range f.Blocks {
+		for _, v := range b.Values {
+			ops[v.Op]++
+		}
+	}
+	return ops
+}
+
+// opCount is the expected number of values of an op.
+type opCount struct {
+	op ssa.Op
+	n  int
+}
+
+// checkOps checks that f, built from the function fn, has the expected
+// number of values of each op in want.
+func checkOps(t *testing.T, fn string, f *ssa.Func, want []opCount) {
+	ops := countOps(f)
+	for _, w := range want {
+		if ops[w.op] != w.n {
+			t.Errorf("%v: got %v %v values, want %v", fn, ops[w.op], w.op, w.n)
+		}
+	}
+}
+
+const convSrc = `package p
+
+func u64tof64(x uint64) float64 { return float64(x) }
+func u64tof32(x uint64) float32 { return float32(x) }
+func f64tou64(x float64) uint64 { return uint64(x) }
+func f32tou64(x float32) uint64 { return uint64(x) }
+func i64toi32(x int64) int32    { return int32(x) }
+func i8toi64(x int8) int64      { return int64(x) }
+func u16tou64(x uint16) uint64  { return uint64(x) }
+`
+
+func TestConv(t *testing.T) {
+	tests := []struct {
+		fn   string
+		want []opCount
+	}{
+		// x>>63 and x>>s, one signed conversion of the halved value and
+		// one of s, which doubles the result when the top bit is set
+		{"u64tof64", []opCount{{ssa.OpRsh64Ux64, 2}, {ssa.OpCvt64to64F, 2}, {ssa.OpMul64F, 1}, {ssa.OpAdd64F, 1}}},
+		{"u64tof32", []opCount{{ssa.OpRsh64Ux64, 2}, {ssa.OpCvt64to32F, 2}, {ssa.OpMul32F, 1}, {ssa.OpAdd32F, 1}}},
+		// a direct conversion and one of x-1<<63, selected by the sign
+		// of the direct one
+		{"f64tou64", []opCount{{ssa.OpCvt64Fto64, 2}, {ssa.OpSub64F, 1}, {ssa.OpXor64, 1}, {ssa.OpRsh64x64, 1}}},
+		{"f32tou64", []opCount{{ssa.OpCvt32Fto64, 2}, {ssa.OpSub32F, 1}, {ssa.OpXor64, 1}, {ssa.OpRsh64x64, 1}}},
+		{"i64toi32", []opCount{{ssa.OpTrunc64to32, 1}}},
+		{"i8toi64", []opCount{{ssa.OpSignExt8to64, 1}}},
+		{"u16tou64", []opCount{{ssa.OpZeroExt16to64, 1}}},
+	}
+	for _, test := range tests {
+		f := buildTestFunc(t, convSrc, test.fn)
+		checkOps(t, test.fn, f, test.want)
+	}
+
+	// the values at and beyond 1<<63 are the ones a signed conversion
+	// gets wrong, 1<<63+1 and 1<<63+1<<11+1 round differently when
+	// halved without keeping the low bit
+	var evals []evalTest
+	for _, x := range []uint64{0, 1, 1<<53 + 1, 1<<63 - 1, 1 << 63, 1<<63 + 1, 1<<63 + 1<<11 + 1, 1<<63 + 1<<40 + 1, math.MaxUint64} {
+		evals = append(evals,
+			evalTest{fn: "u64tof64", args: []interface{}{x}, want: []interface{}{float64(x)}},
+			evalTest{fn: "u64tof32", args: []interface{}{x}, want: []interface{}{float32(x)}})
+	}
+	for _, x := range []float64{0, 1.5, 1<<63 - 1<<10, 1 << 63, 1<<63 + 1<<11, 1.5e19, math.MaxUint64 - 1<<11} {
+		evals = append(evals, evalTest{fn: "f64tou64", args: []interface{}{x}, want: []interface{}{uint64(x)}})
+	}
+	for _, x := range []float32{0, 1.5, 1<<63 - 1<<39, 1 << 63, 1<<63 + 1<<40, 1.5e19} {
+		evals = append(evals, evalTest{fn: "f32tou64", args: []interface{}{x}, want: []interface{}{uint64(x)}})
+	}
+	for _, x := range []int64{0, -1, 1<<31 - 1, 1 << 31, -1<<40 | 5} {
+		evals = append(evals, evalTest{fn: "i64toi32", args: []interface{}{x}, want: []interface{}{int32(x)}})
+	}
+	for _, x := range []int8{0, 127, -128, -3} {
+		evals = append(evals, evalTest{fn: "i8toi64", args: []interface{}{x}, want: []interface{}{int64(x)}})
+	}
+	for _, x := range []uint16{0, 1, 0x8000, 0xffff} {
+		evals = append(evals, evalTest{fn: "u16tou64", args: []interface{}{x}, want: []interface{}{uint64(x)}})
+	}
+	checkEval(t, convSrc, evals)
+}
+
+// countBlocks returns the number of blocks of f of kind k.
+func countBlocks(f *ssa.Func, k ssa.BlockKind) int {
+	n := 0
+	for _, b := range f.Blocks {
+		if b.Kind == k {
+			n++
+		}
+	}
+	return n
+}
+
+const indexSrc = `package p
+
+func index(a []int, i int) int { return a[i] }
+
+//ssair:nobounds
+func indexNoBounds(a []int, i int) int { return a[i] }
+
+func indexString(s string, i int) byte { return s[i] }
+
+//ssair:nobounds
+func indexStringNoBounds(s string, i int) byte { return s[i] }
+
+func length(a []int, s string) int { return len(a) + cap(a) + len(s) }
+
+type Row [8]int
+
+func row(r Row, i int) int     { return r[i] }
+func rowPtr(r *Row, i int) int { return r[i] }
+func rowLen(r *Row) int        { return len(r) }
+
+func setRow(r *Row, i, x int) int {
+	r[i] = x
+	return r[i]
+}
+`
+
+func TestIndex(t *testing.T) {
+	tests := []struct {
+		fn      string
+		checked bool
+		want    []opCount
+	}{
+		{"index", true, []opCount{{ssa.OpSliceLen, 1}, {ssa.OpPtrIndex, 1}}},
+		{"indexNoBounds", false, []opCount{{ssa.OpPtrIndex, 1}}},
+		{"indexString", true, []opCount{{ssa.OpStringLen, 1}, {ssa.OpAddPtr, 1}}},
+		{"indexStringNoBounds", false, []opCount{{ssa.OpStringLen, 0}, {ssa.OpAddPtr, 1}}},
+		{"length", false, []opCount{{ssa.OpSliceLen, 1}, {ssa.OpSliceCap, 1}, {ssa.OpStringLen, 1}}},
+		// the length of a named array type is known
+		{"row", true, []opCount{{ssa.OpSliceLen, 0}, {ssa.OpPtrIndex, 1}}},
+		{"rowPtr", true, []opCount{{ssa.OpNilCheck, 1}, {ssa.OpPtrIndex, 1}}},
+		{"rowLen", false, []opCount{{ssa.OpNilCheck, 0}}},
+	}
+	for _, test := range tests {
+		f := buildTestFunc(t, indexSrc, test.fn)
+		checkOps(t, test.fn, f, test.want)
+		// a checked index branches to a panicindex call that exits
+		n := 0
+		if test.checked {
+			n = 1
+		}
+		checkOps(t, test.fn, f, []opCount{{ssa.OpIsInBounds, n}, {ssa.OpStaticCall, n}})
+		if got := countBlocks(f, ssa.BlockExit); got != n {
+			t.Errorf("%v: got %v exit blocks, want %v", test.fn, got, n)
+		}
+	}
+
+	a := []int{1, 2, 3}
+	r := [8]int{1, 2, 3, 4, 5, 6, 7, 8}
+	checkEval(t, indexSrc, []evalTest{
+		{fn: "index", args: []interface{}{a, 0}, want: []interface{}{1}},
+		{fn: "index", args: []interface{}{a, 2}, want: []interface{}{3}},
+		{fn: "index", args: []interface{}{a, 3}, panic: "runtime·panicindex"},
+		{fn: "index", args: []interface{}{a, -1}, panic: "runtime·panicindex"},
+		{fn: "index", args: []interface{}{[]int(nil), 0}, panic: "runtime·panicindex"},
+		{fn: "indexNoBounds", args: []interface{}{a, 1}, want: []interface{}{2}},
+		{fn: "indexString", args: []interface{}{"abc", 1}, want: []interface{}{byte('b')}},
+		{fn: "indexString", args: []interface{}{"abc", 3}, panic: "runtime·panicindex"},
+		{fn: "indexStringNoBounds", args: []interface{}{"abc", 2}, want: []interface{}{byte('c')}},
+		{fn: "length", args: []interface{}{make([]int, 2, 5), "abc"}, want: []interface{}{10}},
+		{fn: "row", args: []interface{}{r, 7}, want: []interface{}{8}},
+		{fn: "row", args: []interface{}{r, 8}, panic: "runtime·panicindex"},
+		{fn: "rowPtr", args: []interface{}{&r, 2}, want: []interface{}{3}},
+		{fn: "rowPtr", args: []interface{}{&r, -1}, panic: "runtime·panicindex"},
+		{fn: "rowPtr", args: []interface{}{(*[8]int)(nil), 0}, panic: "nil"},
+		{fn: "rowLen", args: []interface{}{(*[8]int)(nil)}, want: []interface{}{8}},
+		{fn: "setRow", args: []interface{}{&r, 4, 9}, want: []interface{}{9}},
+		{fn: "setRow", args: []interface{}{&r, 8, 9}, panic: "runtime·panicindex"},
+	})
+}
+
+const sliceSrc = `package p
+
+func sub(a []int, i, j int) []int     { return a[i:j] }
+func head(a []int, j int) []int       { return a[:j] }
+func substr(s string, i int) string   { return s[i:] }
+func full(a []int, i, j, k int) []int { return a[i:j:k] }
+func capOf(a []int, i, j, k int) int  { return cap(a[i:j:k]) }
+
+type Row [8]int
+
+func rowSlice(r *Row, i, j int) []int { return r[i:j] }
+`
+
+func TestSlice(t *testing.T) {
+	tests := []struct {
+		fn   string
+		ptr  ssa.Op // the op of the pointer being sliced
+		want []opCount
+	}{
+		{"sub", ssa.OpSlicePtr, []opCount{{ssa.OpSliceMake, 1}, {ssa.OpSliceLen, 1}, {ssa.OpSliceCap, 1}, {ssa.OpIsSliceInBounds, 2}, {ssa.OpPtrIndex, 1}}},
+		{"head", ssa.OpSlicePtr, []opCount{{ssa.OpSliceMake, 1}, {ssa.OpIsSliceInBounds, 2}, {ssa.OpPtrIndex, 0}, {ssa.OpPhi, 0}}},
+		{"substr", ssa.OpStringPtr, []opCount{{ssa.OpStringMake, 1}, {ssa.OpStringLen, 1}, {ssa.OpIsSliceInBounds, 1}, {ssa.OpPtrIndex, 1}}},
+		{"full", ssa.OpSlicePtr, []opCount{{ssa.OpSliceMake, 1}, {ssa.OpIsSliceInBounds, 3}, {ssa.OpPtrIndex, 1}}},
+	}
+	for _, test := range tests {
+		f := buildTestFunc(t, sliceSrc, test.fn)
+		checkOps(t, test.fn, f, test.want)
+		if countOps(f)[ssa.OpPtrIndex] == 0 {
+			continue
+		}
+		// the pointer is only advanced if the result capacity isn't
+		// zero, otherwise it's the original pointer
+		var phis []*ssa.Value
+		for _, b := range f.Blocks {
+			for _, v := range b.Values {
+				if v.Op == ssa.OpPhi {
+					phis = append(phis, v)
+				}
+			}
+		}
+		if len(phis) != 1 {
+			t.Errorf("%v: got %v phis, want 1", test.fn, len(phis))
+			continue
+		}
+		ops := map[ssa.Op]bool{}
+		for _, a := range phis[0].Args {
+			ops[a.Op] = true
+		}
+		if len(phis[0].Args) != 2 || !ops[test.ptr] || !ops[ssa.OpPtrIndex] {
+			t.Errorf("%v: got phi %v, want a phi of the %v and PtrIndex values", test.fn, phis[0].LongString(), test.ptr)
+		}
+	}
+
+	a := []int{1, 2, 3, 4}
+	r := [8]int{1, 2, 3, 4, 5, 6, 7, 8}
+	checkEval(t, sliceSrc, []evalTest{
+		{fn: "sub", args: []interface{}{a, 1, 3}, want: []interface{}{[]int{2, 3}}},
+		{fn: "sub", args: []interface{}{a, 4, 4}, want: []interface{}{[]int{}}},
+		{fn: "sub", args: []interface{}{a, 3, 2}, panic: "runtime·panicslice"},
+		{fn: "sub", args: []interface{}{a, 0, 5}, panic: "runtime·panicslice"},
+		{fn: "sub", args: []interface{}{a, -1, 2}, panic: "runtime·panicslice"},
+		{fn: "head", args: []interface{}{a, 2}, want: []interface{}{[]int{1, 2}}},
+		{fn: "head", args: []interface{}{a, 5}, panic: "runtime·panicslice"},
+		{fn: "substr", args: []interface{}{"hello", 2}, want: []interface{}{"llo"}},
+		{fn: "substr", args: []interface{}{"hello", 5}, want: []interface{}{""}},
+		{fn: "substr", args: []interface{}{"hello", 6}, panic: "runtime·panicslice"},
+		{fn: "full", args: []interface{}{a, 1, 2, 3}, want: []interface{}{[]int{2}}},
+		{fn: "full", args: []interface{}{a, 1, 3, 2}, panic: "runtime·panicslice"},
+		{fn: "full", args: []interface{}{a, 1, 2, 5}, panic: "runtime·panicslice"},
+		{fn: "capOf", args: []interface{}{a, 1, 2, 3}, want: []interface{}{2}},
+		{fn: "capOf", args: []interface{}{a, 4, 4, 4}, want: []interface{}{0}},
+		{fn: "rowSlice", args: []interface{}{&r, 6, 8}, want: []interface{}{[]int{7, 8}}},
+		{fn: "rowSlice", args: []interface{}{&r, 0, 9}, panic: "runtime·panicslice"},
+		{fn: "rowSlice", args: []interface{}{(*[8]int)(nil), 0, 1}, panic: "nil"},
+	})
+}
+
+func TestSplit(t *testing.T) {
+	var e ssaExport
+	n := &ssaAuto{name: "x"}
+	ptrSize := StdSizes().WordSize
+	check := func(what string, got ssa.LocalSlot, off, size int64) {
+		if got.N != n || got.Off != off || got.Type.Size() != size {
+			t.Errorf("%v: got slot %v+%v of size %v, want %v+%v of size %v", what, got.N, got.Off, got.Type.Size(), n, off, size)
+		}
+	}
+
+	str := ssa.LocalSlot{N: n, Type: Typ[types.String], Off: 8}
+	p, l := e.SplitString(str)
+	check("string ptr", p, 8, ptrSize)
+	check("string len", l, 8+ptrSize, ptrSize)
+
+	slice := ssa.LocalSlot{N: n, Type: &Type{types.NewSlice(types.Typ[types.Int32])}, Off: 8}
+	p, l, c := e.SplitSlice(slice)
+	check("slice ptr", p, 8, ptrSize)
+	check("slice len", l, 8+ptrSize, ptrSize)
+	check("slice cap", c, 8+2*ptrSize, ptrSize)
+	if elem := p.Type.ElemType(); elem.Size() != 4 {
+		t.Errorf("slice ptr: got a pointer to %v, want *int32", elem)
+	}
+
+	iface := ssa.LocalSlot{N: n, Type: &Type{types.NewInterface(nil, nil)}, Off: 0}
+	itab, data := e.SplitInterface(iface)
+	check("interface itab", itab, 0, ptrSize)
+	check("interface data", data, ptrSize, ptrSize)
+}
+
+const complexSrc = `package p
+
+func mul128(a, b complex128) complex128 { return a * b }
+func mul64(a, b complex64) complex64    { return a * b }
+func add128(a, b complex128) complex128 { return a + b }
+`
+
+func TestComplex(t *testing.T) {
+	tests := []struct {
+		fn  string
+		ops []opCount
+	}{
+		// complex64 products are computed in float64
+		{"mul128", []opCount{{ssa.OpMul64F, 4}, {ssa.OpSub64F, 1}, {ssa.OpAdd64F, 1}, {ssa.OpCvt32Fto64F, 0}}},
+		{"mul64", []opCount{{ssa.OpMul64F, 4}, {ssa.OpCvt32Fto64F, 4}, {ssa.OpCvt64Fto32F, 2}}},
+		{"add128", []opCount{{ssa.OpAdd64F, 2}}},
+	}
+	for _, test := range tests {
+		f := buildTestFunc(t, complexSrc, test.fn)
+		checkOps(t, test.fn, f, test.ops)
+	}
+
+	// (1+2i)(3+4i) = (3-8) + (4+6)i
+	checkEval(t, complexSrc, []evalTest{
+		{fn: "mul128", args: []interface{}{1 + 2i, 3 + 4i}, want: []interface{}{-5 + 10i}},
+		{fn: "mul64", args: []interface{}{complex64(1 + 2i), complex64(3 + 4i)}, want: []interface{}{complex64(-5 + 10i)}},
+		{fn: "add128", args: []interface{}{1 + 2i, 3 + 4i}, want: []interface{}{4 + 6i}},
+	})
+}
+
+const structSrc = `package p
+
+type T struct {
+	a int8
+	b int
+	c struct{ x, y int32 }
+}
+
+func get(p *T) int { return p.b }
+
+func set(p *T, x int) int {
+	p.b = x
+	return p.b
+}
+
+func setNested(p *T, y int32) T {
+	p.c.y = y
+	return *p
+}
+
+func getValue(t T) int32 { return t.c.y }
+
+func setValue(t T, y int32) T {
+	t.c.y = y
+	return t
+}
+`
+
+// testT is the type T of structSrc.
+type testT struct {
+	a int8
+	b int
+	c struct{ x, y int32 }
+}
+
+func TestStruct(t *testing.T) {
+	// the fields of *p are loaded and stored through the nil checked
+	// pointer, not selected from an SSA value
+	tests := []struct {
+		fn     string
+		checks int
+	}{
+		{"get", 1},
+		{"set", 2},
+		{"setNested", 2},
+	}
+	for _, test := range tests {
+		f := buildTestFunc(t, structSrc, test.fn)
+		checkOps(t, test.fn, f, []opCount{{ssa.OpNilCheck, test.checks}, {ssa.OpStructSelect, 0}})
+	}
+
+	v := testT{a: 1, b: 2}
+	v.c.x, v.c.y = 3, 4
+	w := v
+	w.c.y = 5
+	checkEval(t, structSrc, []evalTest{
+		{fn: "get", args: []interface{}{&v}, want: []interface{}{2}},
+		{fn: "get", args: []interface{}{(*testT)(nil)}, panic: "nil"},
+		{fn: "set", args: []interface{}{&v, 7}, want: []interface{}{7}},
+		{fn: "set", args: []interface{}{(*testT)(nil), 7}, panic: "nil"},
+		{fn: "setNested", args: []interface{}{&v, int32(5)}, want: []interface{}{w}},
+		{fn: "getValue", args: []interface{}{v}, want: []interface{}{int32(4)}},
+		{fn: "setValue", args: []interface{}{v, int32(5)}, want: []interface{}{w}},
+	})
+}
+
+const shiftSrc = `package p
+
+func shl(x uint32, n uint) uint32 { return x << n }
+func sar(x int8, n uint8) int8    { return x >> n }
+func shlConst(x int64) int64      { return x << 3 }
+func rot(x uint32) uint32         { return x<<3 | x>>29 }
+func shlSigned(x, n int) int      { return x << n }
+
+func shlAssignSigned(x int, n int32) int {
+	x <<= n
+	return x
+}
+`
+
+func TestShift(t *testing.T) {
+	checkOps(t, "rot", buildTestFunc(t, shiftSrc, "rot"), []opCount{{ssa.OpLrot32, 1}, {ssa.OpLsh32x64, 0}})
+	checkEval(t, shiftSrc, []evalTest{
+		{fn: "shl", args: []interface{}{uint32(1), uint(31)}, want: []interface{}{uint32(1 << 31)}},
+		{fn: "shl", args: []interface{}{uint32(1), uint(32)}, want: []interface{}{uint32(0)}},
+		{fn: "shl", args: []interface{}{uint32(1), uint(1 << 40)}, want: []interface{}{uint32(0)}},
+		{fn: "sar", args: []interface{}{int8(-128), uint8(3)}, want: []interface{}{int8(-16)}},
+		{fn: "sar", args: []interface{}{int8(-128), uint8(200)}, want: []interface{}{int8(-1)}},
+		{fn: "sar", args: []interface{}{int8(64), uint8(8)}, want: []interface{}{int8(0)}},
+		{fn: "shlConst", args: []interface{}{int64(-5)}, want: []interface{}{int64(-40)}},
+		{fn: "rot", args: []interface{}{uint32(0xf0000001)}, want: []interface{}{uint32(0x8000000f)}},
+	})
+
+	// a variable count of a signed type could be negative
+	for _, fn := range []string{"shlSigned", "shlAssignSigned"} {
+		if err := buildError(t, shiftSrc, fn); !strings.Contains(err, "must be a constant") {
+			t.Errorf("%v: got error %q, want a signed shift count error", fn, err)
+		}
+	}
+}
+
+const intrinsicSrc = `package p
+
+import (
+	"github.com/bjwbell/ssa"
+	"github.com/bjwbell/ssair"
+)
+
+func add(x, y int64) int64 {
+	z := ssair.Op2(ssa.OpAMD64ADDQ, x, y)
+	return z.(int64)
+}
+
+func neg(x, y int64) int64 {
+	z := ssair.Op2(ssa.OpAMD64ADDQ, x, y)
+	w := ssair.Op1(ssa.OpAMD64NEGQ, z)
+	return w.(int64)
+}
+
+func xor(a, b ssair.M128i) ssair.M128i {
+	c := ssair.Op2(ssa.OpAMD64PXOR, a, b)
+	return c.(ssair.M128i)
+}
+
+func cvt(x float64) int64 {
+	i := ssair.Op1(ssa.OpAMD64CVTTSD2SQ, x)
+	return i.(int64)
+}
+
+func unsupported(x, y int64) int64 {
+	z := ssair.Op2(ssa.OpAdd64, x, y)
+	return z.(int64)
+}
+
+func arity(x int64) int64 {
+	z := ssair.Op1(ssa.OpAMD64ADDQ, x)
+	return z.(int64)
+}
+
+func class(x, y int32) int32 {
+	z := ssair.Op2(ssa.OpAMD64ADDQ, x, y)
+	return z.(int32)
+}
+
+func mixed(x int64, y uint64) int64 {
+	z := ssair.Op2(ssa.OpAMD64ADDQ, x, y)
+	return z.(int64)
+}
+`
+
+func TestIntrinsic(t *testing.T) {
+	tests := []struct {
+		fn   string
+		op   ssa.Op
+		t    ssa.Type
+		args []ssa.Op // the ops of the operands
+	}{
+		{"add", ssa.OpAMD64ADDQ, Typ[types.Int64], []ssa.Op{ssa.OpArg, ssa.OpArg}},
+		{"neg", ssa.OpAMD64NEGQ, Typ[types.Int64], []ssa.Op{ssa.OpAMD64ADDQ}},
+		{"xor", ssa.OpAMD64PXOR, ssa.TypeInt128, []ssa.Op{ssa.OpArg, ssa.OpArg}},
+		{"cvt", ssa.OpAMD64CVTTSD2SQ, Typ[types.Int64], []ssa.Op{ssa.OpArg}},
+	}
+	for _, test := range tests {
+		f := buildTestFunc(t, intrinsicSrc, test.fn)
+		var v *ssa.Value
+		for _, b := range f.Blocks {
+			for _, w := range b.Values {
+				if w.Op == test.op {
+					v = w
+				}
+			}
+		}
+		if v == nil {
+			t.Errorf("%v: no %v value", test.fn, test.op)
+			continue
+		}
+		if test.t.Compare(v.Type) != ssa.CMPeq {
+			t.Errorf("%v: got %v of type %v, want %v", test.fn, test.op, v.Type, test.t)
+		}
+		var ops []ssa.Op
+		for _, a := range v.Args {
+			ops = append(ops, a.Op)
+		}
+		if !reflect.DeepEqual(ops, test.args) {
+			t.Errorf("%v: got %v operands %v, want %v", test.fn, test.op, ops, test.args)
+		}
+	}
+
+	errs := []struct {
+		fn, err string
+	}{
+		{"unsupported", "isn't a supported intrinsic"},
+		{"arity", "takes 2 operands, not 1"},
+		{"class", "isn't in the operand class"},
+		{"mixed", "has type uint64, not int64"},
+	}
+	for _, test := range errs {
+		if err := buildError(t, intrinsicSrc, test.fn); !strings.Contains(err, test.err) {
+			t.Errorf("%v: got error %q, want %q", test.fn, err, test.err)
+		}
+	}
+}
+
+const vectorSrc = `package p
+
+import "github.com/bjwbell/ssair"
+
+func cast(x ssair.M128d) ssair.M128 { return ssair.M128(x) }
+func load(p *ssair.M128i) ssair.M128i { return *p }
+
+func store(p *ssair.M128i, x ssair.M128i) ssair.M128i {
+	*p = x
+	return *p
+}
+
+func zero() ssair.M128i {
+	var x ssair.M128i
+	return x
+}
+`
+
+func TestVector(t *testing.T) {
+	// the values of the vector types are ssa.TypeInt128 values, the
+	// ones the register allocator assigns to the X registers
+	for _, fn := range []string{"cast", "load", "store", "zero"} {
+		f := buildTestFunc(t, vectorSrc, fn)
+		for _, b := range f.Blocks {
+			for _, v := range b.Values {
+				if vt, ok := v.Type.(*Type); ok && vt.IsVector() {
+					t.Errorf("%v: %v has type %v, not TypeInt128", fn, v.LongString(), vt)
+				}
+			}
+		}
+	}
+	checkOps(t, "cast", buildTestFunc(t, vectorSrc, "cast"), []opCount{{ssa.OpCopy, 1}})
+	checkOps(t, "load", buildTestFunc(t, vectorSrc, "load"), []opCount{{ssa.OpAMD64MOVOload, 1}})
+	checkOps(t, "zero", buildTestFunc(t, vectorSrc, "zero"), []opCount{{ssa.OpAMD64MOVOconst, 1}})
+
+	x := [16]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+	checkEval(t, vectorSrc, []evalTest{
+		{fn: "cast", args: []interface{}{x}, want: []interface{}{x}},
+		{fn: "load", args: []interface{}{&x}, want: []interface{}{x}},
+		{fn: "load", args: []interface{}{(*[16]byte)(nil)}, panic: "nil"},
+		{fn: "store", args: []interface{}{&[16]byte{}, x}, want: []interface{}{x}},
+		{fn: "zero", want: []interface{}{[16]byte{}}},
+	})
+}
+
+const phiSrc = `package p
+
+import "github.com/bjwbell/ssair"
+
+func phi(vars map[string]float64) float64 { panic("phi") }
+
+// the values are listed in the opposite order of the predecessors
+func pick(c bool, a, b float64) float64 {
+	if c {
+		goto yes
+	} else {
+		goto no
+	}
+yes:
+	goto done
+no:
+	goto done
+done:
+	x := phi(map[string]float64{"no": b, "yes": a})
+	return x
+}
+
+func pickInt(c bool, a, b int) int {
+	if c {
+		goto yes
+	} else {
+		goto no
+	}
+yes:
+	goto done
+no:
+	goto done
+done:
+	var x int = ssair.Phi(map[string]int{"no": b, "yes": a})
+	return x
+}
+
+func missing(c bool, a, b float64) float64 {
+	if c {
+		goto yes
+	} else {
+		goto no
+	}
+yes:
+	goto done
+no:
+	goto done
+done:
+	x := phi(map[string]float64{"yes": a})
+	return x
+}
+
+func notPred(c bool, a, b float64) float64 {
+	if c {
+		goto yes
+	} else {
+		goto no
+	}
+yes:
+	goto done
+no:
+	goto done
+done:
+	x := phi(map[string]float64{"no": b, "yes": a, "_": a})
+	return x
+}
+
+func unassigned(c bool, a, b float64) float64 {
+	if c {
+		goto yes
+	} else {
+		goto no
+	}
+yes:
+	goto done
+no:
+	goto done
+done:
+	return phi(map[string]float64{"no": b, "yes": a})
+}
+`
+
+func TestPhi(t *testing.T) {
+	// the args are in the order of the predecessors, yes then no
+	for _, fn := range []string{"pick", "pickInt"} {
+		f := buildTestFunc(t, phiSrc, fn)
+		for _, b := range f.Blocks {
+			for _, v := range b.Values {
+				if v.Op != ssa.OpPhi {
+					continue
+				}
+				var args []string
+				for _, a := range v.Args {
+					for a.Op == ssa.OpCopy {
+						a = a.Args[0]
+					}
+					args = append(args, a.Aux.(*ssaParam).Name())
+				}
+				if !reflect.DeepEqual(args, []string{"a", "b"}) {
+					t.Errorf("%v: got phi args %v, want [a b]", fn, args)
+				}
+			}
+		}
+	}
+	checkOps(t, "pick", buildTestFunc(t, phiSrc, "pick"), []opCount{{ssa.OpPhi, 1}})
+	checkEval(t, phiSrc, []evalTest{
+		{fn: "pick", args: []interface{}{true, 1.5, 2.5}, want: []interface{}{1.5}},
+		{fn: "pick", args: []interface{}{false, 1.5, 2.5}, want: []interface{}{2.5}},
+		{fn: "pickInt", args: []interface{}{true, 1, 2}, want: []interface{}{1}},
+		{fn: "pickInt", args: []interface{}{false, 1, 2}, want: []interface{}{2}},
+	})
+
+	errs := []struct {
+		fn, err string
+	}{
+		{"missing", "has no value for predecessor no"},
+		{"notPred", "has a value for _, which isn't a predecessor"},
+		{"unassigned", "must be assigned to a variable"},
+	}
+	for _, test := range errs {
+		if err := buildError(t, phiSrc, test.fn); !strings.Contains(err, test.err) {
+			t.Errorf("%v: got error %q, want %q", test.fn, err, test.err)
+		}
+	}
+}
+
+const ifaceSrc = `package p
+
+type Reader interface {
+	Read() int
+}
+
+type ReadWriter interface {
+	Read() int
+	Write() int
+}
+
+func toEmpty(r Reader) interface{}    { return interface{}(r) }
+func fromInt(x int) interface{}       { return interface{}(x) }
+func toReader(rw ReadWriter) Reader { return Reader(rw) }
+`
+
+func TestInterface(t *testing.T) {
+	checkOps(t, "toEmpty", buildTestFunc(t, ifaceSrc, "toEmpty"), []opCount{{ssa.OpITab, 1}, {ssa.OpIData, 1}, {ssa.OpIMake, 1}})
+
+	// the type is the second word of the itab
+	f, sig := buildTestFn(t, ifaceSrc, "toEmpty")
+	typ := newObject(Typ[types.Int], uint64(1))
+	itab := ptr{&object{map[int64]interface{}{StdSizes().WordSize: typ}}, 0}
+	data := newObject(Typ[types.Int], uint64(2))
+	results, panicked := eval(t, f, sig, []interface{}{iface{itab, data}})
+	if want := (iface{typ, data}); panicked != "" || results[0] != want {
+		t.Errorf("toEmpty: got %v (panic %q), want %v", results, panicked, want)
+	}
+	checkEval(t, ifaceSrc, []evalTest{
+		{fn: "toEmpty", args: []interface{}{nil}, want: []interface{}{nil}},
+	})
+
+	// the other conversions need the runtime
+	for _, fn := range []string{"fromInt", "toReader"} {
+		if err := buildError(t, ifaceSrc, fn); !strings.Contains(err, "isn't supported") {
+			t.Errorf("%v: got error %q, want an unsupported conversion error", fn, err)
+		}
+	}
+}
+
+const loopSrc = `package p
+
+func sum(n int) int {
+	i := 0
+	s := 0
+	goto loop
+loop:
+	if i < n {
+		goto body
+	} else {
+		goto done
+	}
+body:
+	s = s + i
+	i = i + 1
+	goto loop
+done:
+	return s
+}
+
+// n is only read in the loop, so it needs no phi
+func count(n, k int) int {
+	i := 0
+	goto loop
+loop:
+	if i < n {
+		goto body
+	} else {
+		goto done
+	}
+body:
+	i = i + k
+	goto loop
+done:
+	return i
+}
+`
+
+func TestLoop(t *testing.T) {
+	// the loop block has phis for i and s
+	checkOps(t, "sum", buildTestFunc(t, loopSrc, "sum"), []opCount{{ssa.OpPhi, 2}, {ssa.OpFwdRef, 0}})
+	checkOps(t, "count", buildTestFunc(t, loopSrc, "count"), []opCount{{ssa.OpPhi, 1}, {ssa.OpFwdRef, 0}})
+	checkEval(t, loopSrc, []evalTest{
+		{fn: "sum", args: []interface{}{0}, want: []interface{}{0}},
+		{fn: "sum", args: []interface{}{1}, want: []interface{}{0}},
+		{fn: "sum", args: []interface{}{5}, want: []interface{}{10}},
+		{fn: "sum", args: []interface{}{100}, want: []interface{}{4950}},
+		{fn: "count", args: []interface{}{10, 3}, want: []interface{}{12}},
+		{fn: "count", args: []interface{}{-1, 3}, want: []interface{}{0}},
+	})
+}
+
+const swapSrc = `package p
+
+type P struct{ x, y int }
+
+func swap(a, b int) (int, int) {
+	a, b = b, a
+	return a, b
+}
+
+func rotate(a, b, c int) (int, int, int) {
+	a, b, c = b, c, a
+	return a, b, c
+}
+
+func swapFields(p *P) P {
+	p.x, p.y = p.y, p.x
+	return *p
+}
+
+// the state of the loop is updated with a parallel assignment
+func fib(n int) int {
+	a, b := 0, 1
+	goto loop
+loop:
+	if n > 0 {
+		goto body
+	} else {
+		goto done
+	}
+body:
+	a, b = b, a+b
+	n = n - 1
+	goto loop
+done:
+	return a
+}
+`
+
+func TestSwap(t *testing.T) {
+	type testP struct{ x, y int }
+	checkEval(t, swapSrc, []evalTest{
+		{fn: "swap", args: []interface{}{1, 2}, want: []interface{}{2, 1}},
+		{fn: "rotate", args: []interface{}{1, 2, 3}, want: []interface{}{2, 3, 1}},
+		{fn: "swapFields", args: []interface{}{&testP{1, 2}}, want: []interface{}{testP{2, 1}}},
+		{fn: "fib", args: []interface{}{0}, want: []interface{}{0}},
+		{fn: "fib", args: []interface{}{1}, want: []interface{}{1}},
+		{fn: "fib", args: []interface{}{10}, want: []interface{}{55}},
+	})
+}
+
+const opAssignSrc = `package p
+
+type P struct{ x, y int }
+
+func add(x, y int) int            { x += y; return x }
+func sub(x, y int16) int16        { x -= y; return x }
+func mul(x, y int) int            { x *= y; return x }
+func divMod(x, y int) (int, int)  { q, r := x, x; q /= y; r %= y; return q, r }
+func andNot(x, y uint8) uint8     { x &^= y; return x }
+func or(x, y uint8) uint8         { x |= y; return x }
+func xor(x, y uint8) uint8        { x ^= y; return x }
+func shl(x uint32, n uint) uint32 { x <<= n; return x }
+func shr(x int8, n uint8) int8    { x >>= n; return x }
+func inc(x int) int               { x++; return x }
+func dec(x int8) int8             { x--; return x }
+
+func incPtr(p *int) int {
+	*p++
+	*p += 10
+	return *p
+}
+
+func field(p *P, k int) P {
+	p.x *= k
+	p.y--
+	return *p
+}
+`
+
+func TestOpAssign(t *testing.T) {
+	type testP struct{ x, y int }
+	checkEval(t, opAssignSrc, []evalTest{
+		{fn: "add", args: []interface{}{3, 4}, want: []interface{}{7}},
+		{fn: "sub", args: []interface{}{int16(-32768), int16(1)}, want: []interface{}{int16(32767)}},
+		{fn: "mul", args: []interface{}{-3, 4}, want: []interface{}{-12}},
+		{fn: "divMod", args: []interface{}{-7, 2}, want: []interface{}{-3, -1}},
+		{fn: "andNot", args: []interface{}{uint8(0xff), uint8(0x0f)}, want: []interface{}{uint8(0xf0)}},
+		{fn: "or", args: []interface{}{uint8(0xf0), uint8(0x0f)}, want: []interface{}{uint8(0xff)}},
+		{fn: "xor", args: []interface{}{uint8(0xff), uint8(0x0f)}, want: []interface{}{uint8(0xf0)}},
+		{fn: "shl", args: []interface{}{uint32(1), uint(4)}, want: []interface{}{uint32(16)}},
+		{fn: "shr", args: []interface{}{int8(-128), uint8(4)}, want: []interface{}{int8(-8)}},
+		{fn: "inc", args: []interface{}{41}, want: []interface{}{42}},
+		{fn: "dec", args: []interface{}{int8(-128)}, want: []interface{}{int8(127)}},
+		{fn: "incPtr", args: []interface{}{new(int)}, want: []interface{}{11}},
+		{fn: "incPtr", args: []interface{}{(*int)(nil)}, panic: "nil"},
+		{fn: "field", args: []interface{}{&testP{3, 5}, 2}, want: []interface{}{testP{6, 4}}},
+	})
+}
+
+const declSrc = `package p
+
+func zero() (uint64, string, float64) {
+	var a uint64
+	var s string
+	var f float64
+	return a, s, f
+}
+
+func init2(x int) int {
+	var a, b = x, 2
+	var c int = a * b
+	return c
+}
+
+func addrTaken(x int) int {
+	var a int
+	p := &a
+	*p = *p + x
+	return a
+}
+
+func consts() (int, uint8, float64) {
+	const (
+		a = iota * 10
+		b
+		c
+	)
+	const k uint8 = 200 + 55
+	const f = 1.0 / 4
+	return c, k, f
+}
+
+// acc is zero on the first iteration
+func sum(n uint64) uint64 {
+	var acc uint64
+	goto loop
+loop:
+	if n == 0 {
+		goto done
+	}
+body:
+	acc = acc + n
+	n = n - 1
+	goto loop
+done:
+	return acc
+}
+`
+
+func TestDecl(t *testing.T) {
+	// the constants are folded
+	checkOps(t, "consts", buildTestFunc(t, declSrc, "consts"), []opCount{{ssa.OpMul64, 0}, {ssa.OpAdd8, 0}, {ssa.OpDiv64F, 0}})
+	checkEval(t, declSrc, []evalTest{
+		{fn: "zero", want: []interface{}{uint64(0), "", 0.0}},
+		{fn: "init2", args: []interface{}{21}, want: []interface{}{42}},
+		{fn: "addrTaken", args: []interface{}{5}, want: []interface{}{5}},
+		{fn: "consts", want: []interface{}{20, uint8(255), 0.25}},
+		{fn: "sum", args: []interface{}{uint64(0)}, want: []interface{}{uint64(0)}},
+		{fn: "sum", args: []interface{}{uint64(4)}, want: []interface{}{uint64(10)}},
+	})
+}
+
+const fallthroughSrc = `package p
+
+//ssair:fallthrough
+func steps(x int) int {
+	x = x + 1
+a:
+	x = x * 2
+b:
+	x = x - 3
+	if x > 0 {
+		goto c
+	} else {
+		goto d
+	}
+c:
+	x = x * 10
+d:
+	return x
+}
+
+func noPragma(x int) int {
+	x = x + 1
+a:
+	x = x * 2
+b:
+	return x
+}
+`
+
+func TestFallthrough(t *testing.T) {
+	// entry falls through to a, a to b and c to d
+	f := buildTestFunc(t, fallthroughSrc, "steps")
+	if n := countBlocks(f, ssa.BlockPlain); n != 3 {
+		t.Errorf("steps: got %v plain blocks, want 3", n)
+	}
+	checkEval(t, fallthroughSrc, []evalTest{
+		{fn: "steps", args: []interface{}{1}, want: []interface{}{10}},
+		{fn: "steps", args: []interface{}{0}, want: []interface{}{-1}},
+	})
+
+	// without the pragma only the entry block falls through
+	if err := buildError(t, fallthroughSrc, "noPragma"); !strings.Contains(err, "transfer control") {
+		t.Errorf("noPragma: got error %q, want a missing transfer of control error", err)
+	}
+}
+
+const condSrc = `package p
+
+func and(a, b int, c bool) int {
+	if a < b && !c {
+		goto x
+	} else {
+		goto y
+	}
+x:
+	return 1
+y:
+	return 2
+}
+
+func or(a, b int, c bool) int {
+	if a < b || c {
+		goto x
+	} else {
+		goto y
+	}
+x:
+	return 1
+y:
+	return 2
+}
+
+func nested(a, b, c bool) int {
+	if (a || b) && !(c && a) {
+		goto x
+	} else {
+		goto y
+	}
+x:
+	return 1
+y:
+	return 2
+}
+
+// *p isn't loaded when p is nil
+func positive(p *int) int {
+	if p != nil && *p > 0 {
+		goto x
+	} else {
+		goto y
+	}
+x:
+	return 1
+y:
+	return 2
+}
+
+// without an else the false edge goes to the next block
+func abs(a int) int {
+	if a > 0 {
+		goto pos
+	}
+neg:
+	return -a
+pos:
+	return a
+}
+`
+
+func TestCond(t *testing.T) {
+	// the conditions are branches rather than boolean values
+	for _, fn := range []string{"and", "or", "nested"} {
+		checkOps(t, fn, buildTestFunc(t, condSrc, fn), []opCount{{ssa.OpAndB, 0}, {ssa.OpOrB, 0}, {ssa.OpNot, 0}})
+	}
+	one := 1
+	checkEval(t, condSrc, []evalTest{
+		{fn: "and", args: []interface{}{1, 2, false}, want: []interface{}{1}},
+		{fn: "and", args: []interface{}{1, 2, true}, want: []interface{}{2}},
+		{fn: "and", args: []interface{}{2, 1, false}, want: []interface{}{2}},
+		{fn: "or", args: []interface{}{1, 2, false}, want: []interface{}{1}},
+		{fn: "or", args: []interface{}{2, 1, true}, want: []interface{}{1}},
+		{fn: "or", args: []interface{}{2, 1, false}, want: []interface{}{2}},
+		{fn: "nested", args: []interface{}{true, false, false}, want: []interface{}{1}},
+		{fn: "nested", args: []interface{}{true, false, true}, want: []interface{}{2}},
+		{fn: "nested", args: []interface{}{false, true, true}, want: []interface{}{1}},
+		{fn: "nested", args: []interface{}{false, false, false}, want: []interface{}{2}},
+		{fn: "positive", args: []interface{}{&one}, want: []interface{}{1}},
+		{fn: "positive", args: []interface{}{new(int)}, want: []interface{}{2}},
+		{fn: "positive", args: []interface{}{(*int)(nil)}, want: []interface{}{2}},
+		{fn: "abs", args: []interface{}{3}, want: []interface{}{3}},
+		{fn: "abs", args: []interface{}{-3}, want: []interface{}{3}},
+		{fn: "abs", args: []interface{}{0}, want: []interface{}{0}},
+	})
+}
+
+const switchSrc = `package p
+
+// the cases are too sparse for a range check
+func sparse(x int) int {
+	switch x {
+	case 1000:
+		goto d
+	case -100:
+		goto a
+	case 7, 9:
+		goto c
+	case 1:
+		goto b
+	default:
+		goto e
+	}
+a:
+	return 1
+b:
+	return 2
+c:
+	return 3
+d:
+	return 4
+e:
+	return 5
+}
+
+// the cases are range checked before the search
+func dense(x int8) int {
+	switch x {
+	case 3:
+		goto a
+	case 4, 6:
+		goto b
+	case 5:
+		goto c
+	case 7:
+		goto d
+	default:
+		goto e
+	}
+a:
+	return 1
+b:
+	return 2
+c:
+	return 3
+d:
+	return 4
+e:
+	return 5
+}
+
+// the first case that matches is taken
+func vars(x, y, z int) int {
+	switch x {
+	case y:
+		goto a
+	case z:
+		goto b
+	default:
+		goto c
+	}
+a:
+	return 1
+b:
+	return 2
+c:
+	return 3
+}
+
+func tagless(x int) int {
+	switch {
+	case x < 0:
+		goto a
+	case x == 0 || x == 10:
+		goto b
+	default:
+		goto c
+	}
+a:
+	return 1
+b:
+	return 2
+c:
+	return 3
+}
+`
+
+func TestSwitch(t *testing.T) {
+	checkOps(t, "sparse", buildTestFunc(t, switchSrc, "sparse"), []opCount{{ssa.OpLeq64U, 0}})
+	checkOps(t, "dense", buildTestFunc(t, switchSrc, "dense"), []opCount{{ssa.OpLeq8U, 1}})
+
+	var tests []evalTest
+	sparse := map[int]int{1000: 4, -100: 1, 7: 3, 9: 3, 1: 2}
+	for _, x := range []int{-101, -100, -99, 0, 1, 2, 6, 7, 8, 9, 10, 999, 1000, 1001, math.MinInt64, math.MaxInt64} {
+		want, ok := sparse[x]
+		if !ok {
+			want = 5
+		}
+		tests = append(tests, evalTest{fn: "sparse", args: []interface{}{x}, want: []interface{}{want}})
+	}
+	dense := map[int8]int{3: 1, 4: 2, 6: 2, 5: 3, 7: 4}
+	for _, x := range []int8{-128, -1, 0, 2, 3, 4, 5, 6, 7, 8, 127} {
+		want, ok := dense[x]
+		if !ok {
+			want = 5
+		}
+		tests = append(tests, evalTest{fn: "dense", args: []interface{}{x}, want: []interface{}{want}})
+	}
+	tests = append(tests,
+		evalTest{fn: "vars", args: []interface{}{1, 1, 1}, want: []interface{}{1}},
+		evalTest{fn: "vars", args: []interface{}{1, 2, 1}, want: []interface{}{2}},
+		evalTest{fn: "vars", args: []interface{}{1, 2, 3}, want: []interface{}{3}},
+		evalTest{fn: "tagless", args: []interface{}{-1}, want: []interface{}{1}},
+		evalTest{fn: "tagless", args: []interface{}{0}, want: []interface{}{2}},
+		evalTest{fn: "tagless", args: []interface{}{10}, want: []interface{}{2}},
+		evalTest{fn: "tagless", args: []interface{}{5}, want: []interface{}{3}},
+	)
+	checkEval(t, switchSrc, tests)
+}
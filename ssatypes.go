@@ -28,7 +28,9 @@ func (l *ssaLabel) used() bool { return l.useNode != nil }
 
 // ssaExport exports a bunch of compiler services for the ssa backend.
 type ssaExport struct {
-	log bool
+	log    bool
+	fnType *types.Func // the function being compiled
+	autos  int         // number of temporaries allocated by Auto
 }
 
 func (s *ssaExport) TypeBool() ssa.Type    { return Typ[types.Bool] }
@@ -54,15 +56,22 @@ func (*ssaExport) StringData(s string) interface{} {
 	return nil
 }
 
+// Auto returns a new temporary of type t, used for spill slots.
 func (e *ssaExport) Auto(t ssa.Type) ssa.GCNode {
-	/*n := temp(t.(*Type))   // Note: adds new auto to Curfn.Func.Dcl list
-	e.mustImplement = true // This modifies the input to SSA, so we want to make sure we succeed from here!*/
-	//return n
-	return nil
+	if t == ssa.TypeInt128 {
+		// a spilled SSE vector
+		t = &Type{types.NewArray(types.Typ[types.Uint8], 16)}
+	}
+	n := &ssaAuto{name: fmt.Sprintf("autotmp_%d", e.autos), typ: t.(*Type)}
+	e.autos++
+	return n
 }
 
 func (e *ssaExport) CanSSA(t ssa.Type) bool {
-	return true //canSSAType(t.(*Type))
+	if t == ssa.TypeInt128 {
+		return true
+	}
+	return canSSAType(t.(*Type))
 }
 
 // Log logs a message from the compiler.
@@ -116,26 +125,40 @@ func (e *ssaExport) Log() bool {
 // It is (possibly a subpiece of) a PPARAM, PPARAMOUT, or PAUTO ONAME node.
 
 func (e *ssaExport) SplitString(localSlot ssa.LocalSlot) (ssa.LocalSlot, ssa.LocalSlot) {
-	// TODO
-	return ssa.LocalSlot{}, ssa.LocalSlot{}
+	ptrSize := StdSizes().WordSize
+	ptrType := Typ[types.Uint8].PtrTo()
+	lenType := Typ[types.Int]
+	// the ptr and len words of the string
+	return ssa.LocalSlot{N: localSlot.N, Type: ptrType, Off: localSlot.Off},
+		ssa.LocalSlot{N: localSlot.N, Type: lenType, Off: localSlot.Off + ptrSize}
 }
 
 func (e *ssaExport) SplitInterface(localSlot ssa.LocalSlot) (ssa.LocalSlot, ssa.LocalSlot) {
-	// TODO
-	return ssa.LocalSlot{}, ssa.LocalSlot{}
+	ptrSize := StdSizes().WordSize
+	t := Typ[types.Uint8].PtrTo()
+	// the itab and data words of the interface
+	return ssa.LocalSlot{N: localSlot.N, Type: t, Off: localSlot.Off},
+		ssa.LocalSlot{N: localSlot.N, Type: t, Off: localSlot.Off + ptrSize}
 }
 
 func (e *ssaExport) SplitSlice(localSlot ssa.LocalSlot) (ssa.LocalSlot, ssa.LocalSlot, ssa.LocalSlot) {
-	// TODO
-	return ssa.LocalSlot{}, ssa.LocalSlot{}, ssa.LocalSlot{}
+	ptrSize := StdSizes().WordSize
+	ptrType := localSlot.Type.ElemType().PtrTo()
+	lenType := Typ[types.Int]
+	// the ptr, len and cap words of the slice
+	return ssa.LocalSlot{N: localSlot.N, Type: ptrType, Off: localSlot.Off},
+		ssa.LocalSlot{N: localSlot.N, Type: lenType, Off: localSlot.Off + ptrSize},
+		ssa.LocalSlot{N: localSlot.N, Type: lenType, Off: localSlot.Off + 2*ptrSize}
 }
 
 func (e *ssaExport) SplitComplex(localSlot ssa.LocalSlot) (ssa.LocalSlot, ssa.LocalSlot) {
-	// TODO
-	return ssa.LocalSlot{}, ssa.LocalSlot{}
+	t := floatForComplex(localSlot.Type.(*Type))
+	// the real and imaginary parts of the complex value
+	return ssa.LocalSlot{N: localSlot.N, Type: t, Off: localSlot.Off},
+		ssa.LocalSlot{N: localSlot.N, Type: t, Off: localSlot.Off + t.Size()}
 }
 
 func (e *ssaExport) SplitStruct(localSlot ssa.LocalSlot, i int) ssa.LocalSlot {
-	// TODO
-	return ssa.LocalSlot{}
+	t := localSlot.Type.(*Type)
+	return ssa.LocalSlot{N: localSlot.N, Type: t.FieldType(i), Off: localSlot.Off + t.FieldOff(i)}
 }
@@ -20,10 +20,27 @@ type ssaParam struct {
 	ssaVar
 	v   *types.Var
 	ctx Ctx
+	idx int              // index of the parameter, the receiver is first
+	sig *types.Signature // signature of the function
 }
 
 func (p *ssaParam) Name() string {
-	return p.v.Name()
+	name := p.v.Name()
+	if name == "" {
+		if p.sig != nil && p.sig.Recv() == p.v {
+			return "recv"
+		}
+		// the names go vet uses for unnamed parameters
+		argnum := p.idx
+		if p.sig != nil && p.sig.Recv() != nil {
+			argnum--
+		}
+		name = "arg"
+		if argnum > 0 {
+			name += fmt.Sprint(argnum)
+		}
+	}
+	return name
 }
 
 func (p ssaParam) String() string {
@@ -35,8 +52,12 @@ func (p *ssaParam) Class() NodeClass {
 }
 
 func (p *ssaParam) Xoffset() int64 {
-	// TODO
-	return 0
+	if p.sig == nil {
+		// the FP
+		return 0
+	}
+	params, _, _ := argOffsets(p.sig)
+	return params[p.idx]
 }
 
 func (p ssaParam) Typ() ssa.Type {
@@ -47,14 +68,19 @@ type ssaRetVar struct {
 	ssaVar
 	v   *types.Var
 	ctx Ctx
+	idx int              // index of the result
+	sig *types.Signature // signature of the function
 }
 
 func (p *ssaRetVar) Name() string {
 	name := p.v.Name()
 	if name == "" {
-		name = "ret0"
+		// the names go vet uses for unnamed results
+		name = "ret"
+		if p.idx > 0 {
+			name += fmt.Sprint(p.idx)
+		}
 	}
-	fmt.Println("SSARETVAR.NAME(): ", name)
 	return name
 }
 
@@ -68,8 +94,8 @@ func (p *ssaRetVar) Class() NodeClass {
 }
 
 func (p *ssaRetVar) Xoffset() int64 {
-	// TODO
-	return 8
+	_, results, _ := argOffsets(p.sig)
+	return results[p.idx]
 }
 
 func (p ssaRetVar) Typ() ssa.Type {
@@ -78,8 +104,9 @@ func (p ssaRetVar) Typ() ssa.Type {
 
 type ssaLocal struct {
 	ssaVar
-	obj types.Object
-	ctx Ctx
+	obj     types.Object
+	ctx     Ctx
+	xoffset int64 // offset from the pseudo-SP, set by allocauto
 }
 
 func (local *ssaLocal) Name() string {
@@ -95,10 +122,79 @@ func (local *ssaLocal) Class() NodeClass {
 }
 
 func (local *ssaLocal) Xoffset() int64 {
-	// TODO
-	return 0
+	return local.xoffset
 }
 
 func (local ssaLocal) Typ() ssa.Type {
-	return &Type{local.obj.Type()}
+	return &Type{local.ctx.varType(local.obj)}
+}
+
+// ssaAuto is a temporary in the frame, created by the backend for
+// spilling values that don't fit in registers.
+type ssaAuto struct {
+	ssaVar
+	name    string
+	typ     *Type
+	xoffset int64 // offset from the pseudo-SP, set by allocauto
+}
+
+func (a *ssaAuto) Name() string {
+	return a.name
+}
+
+func (a ssaAuto) String() string {
+	return fmt.Sprintf("{ssaAuto: %v}", a.Name())
+}
+
+func (a *ssaAuto) Class() NodeClass {
+	return PAUTO
+}
+
+func (a *ssaAuto) Xoffset() int64 {
+	return a.xoffset
+}
+
+func (a ssaAuto) Typ() ssa.Type {
+	return a.typ
+}
+
+// argOffsets returns the offsets from FP of the parameters and results of
+// a function with signature sig, and the size of the arguments. The
+// receiver of a method is its first parameter. Following
+// the Go calling convention each parameter is aligned to its own
+// alignment, the results start at the next MaxAlign boundary after the
+// parameters, and the size is a multiple of MaxAlign.
+func argOffsets(sig *types.Signature) (params, results []int64, size int64) {
+	std := StdSizes()
+	var off int64
+	layout := func(tuple *types.Tuple) []int64 {
+		var offs []int64
+		for i := 0; i < tuple.Len(); i++ {
+			t := tuple.At(i).Type()
+			off = align(off, std.Alignof(t))
+			offs = append(offs, off)
+			off += std.Sizeof(t)
+		}
+		return offs
+	}
+	if recv := sig.Recv(); recv != nil {
+		params = layout(types.NewTuple(recv))
+	}
+	params = append(params, layout(sig.Params())...)
+	off = align(off, std.MaxAlign)
+	results = layout(sig.Results())
+	size = align(off, std.MaxAlign)
+	return params, results, size
+}
+
+// align rounds off up to a multiple of a.
+func align(off, a int64) int64 {
+	return (off + a - 1) / a * a
+}
+
+// ArgsSize returns the size of the arguments, parameters and results,
+// of the function fn.
+func ArgsSize(fn *types.Func) int64 {
+	_, _, size := argOffsets(fn.Type().(*types.Signature))
+	return size
 }
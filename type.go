@@ -10,6 +10,10 @@ type Type struct {
 	types.Type
 }
 
+// pkgPath is the import path of this package, the vector types and the
+// Phi and intrinsic functions of SSA-form source are from it.
+const pkgPath = "github.com/bjwbell/ssair"
+
 func StdSizes() types.StdSizes {
 	var std types.StdSizes
 	// TODO: make dependent on arch
@@ -48,10 +52,10 @@ var Typ = []*Type{
 	// types.UntypedNil:     CTNIL
 }
 
-// Basic returns *types.Basic if t.Type is *types.Basic
+// Basic returns *types.Basic if the underlying type of t.Type is *types.Basic
 // else nil is returned.
 func (t *Type) Basic() *types.Basic {
-	if basic, ok := t.Type.(*types.Basic); ok {
+	if basic, ok := t.Type.Underlying().(*types.Basic); ok {
 		return basic
 	}
 	return nil
@@ -60,16 +64,16 @@ func (t *Type) Basic() *types.Basic {
 // Struct returns *types.Struct if t.Type is *types.Struct
 // else nil is returned.
 func (t *Type) Struct() *types.Struct {
-	if s, ok := t.Type.(*types.Struct); ok {
+	if s, ok := t.Type.Underlying().(*types.Struct); ok {
 		return s
 	}
 	return nil
 }
 
-// Array returns *types.Array if t.Type is *types.Array
-// else nil is returned.
+// Array returns *types.Array if the underlying type of t.Type is
+// *types.Array else nil is returned.
 func (t *Type) Array() *types.Array {
-	if array, ok := t.Type.(*types.Array); ok {
+	if array, ok := t.Type.Underlying().(*types.Array); ok {
 		return array
 	}
 	return nil
@@ -80,7 +84,7 @@ func (t *Type) Array() *types.Array {
 func (t *Type) IsBasicInfoFlag(flag types.BasicInfo) bool {
 	if basic := t.Basic(); basic != nil {
 		info := basic.Info()
-		return info&flag == 1
+		return info&flag != 0
 	} else {
 		return false
 	}
@@ -106,6 +110,20 @@ func (t *Type) IsFloat() bool {
 	return t.IsBasicInfoFlag(types.IsFloat)
 }
 
+// IsVector reports whether t is one of the SSE vector types, M128i, M128
+// or M128d.
+func (t *Type) IsVector() bool {
+	named, ok := t.Type.(*types.Named)
+	if !ok || named.Obj().Pkg() == nil || named.Obj().Pkg().Path() != pkgPath {
+		return false
+	}
+	switch named.Obj().Name() {
+	case "M128i", "M128", "M128d":
+		return true
+	}
+	return false
+}
+
 func (t *Type) IsComplex() bool {
 	return t.IsBasicInfoFlag(types.IsComplex)
 }
@@ -114,34 +132,45 @@ func (t *Type) IsPtr() bool {
 	if basic := t.Basic(); basic != nil {
 		return basic.Kind() == types.UnsafePointer
 	}
-	switch t.Type.(type) {
+	switch t.Type.Underlying().(type) {
 	case *types.Pointer, *types.Map, *types.Signature, *types.Chan:
 		return true
 	}
 	return false
 }
 
+func (t *Type) IsUintptr() bool {
+	if basic := t.Basic(); basic != nil {
+		return basic.Kind() == types.Uintptr
+	}
+	return false
+}
+
 func (t *Type) IsString() bool {
 	return t.IsBasicInfoFlag(types.IsString)
 }
 
 func (t *Type) IsMap() bool {
-	_, ok := t.Type.(*types.Map)
+	_, ok := t.Type.Underlying().(*types.Map)
 	return ok
 }
 
 func (t *Type) IsChan() bool {
-	_, ok := t.Type.(*types.Map)
+	_, ok := t.Type.Underlying().(*types.Chan)
 	return ok
 }
 
 func (t *Type) IsSlice() bool {
-	_, ok := t.Type.(*types.Slice)
+	_, ok := t.Type.Underlying().(*types.Slice)
 	return ok
 }
 
 func (t *Type) IsArray() bool {
-	_, ok := t.Type.(*types.Array)
+	if t.IsVector() {
+		// vectors are values in the X registers, they can't be indexed
+		return false
+	}
+	_, ok := t.Type.Underlying().(*types.Array)
 	return ok
 }
 
@@ -150,7 +179,7 @@ func (t *Type) IsStruct() bool {
 }
 
 func (t *Type) IsInterface() bool {
-	_, ok := t.Type.(*types.Interface)
+	_, ok := t.Type.Underlying().(*types.Interface)
 	return ok
 }
 
@@ -170,9 +199,14 @@ func (t *Type) IsVoid() bool   { return false }
 
 // Elem, if t.Type is []T or *T or [n]T, return T, otherwise return nil
 func (t *Type) Elem() ssa.Type {
-	if t.IsSlice() || t.IsPtr() || t.IsArray() {
-		return &Type{t.Underlying()}
-	} else {
+	switch u := t.Type.Underlying().(type) {
+	case *types.Slice:
+		return &Type{u.Elem()}
+	case *types.Pointer:
+		return &Type{u.Elem()}
+	case *types.Array:
+		return &Type{u.Elem()}
+	default:
 		return nil
 	}
 }
@@ -187,8 +221,7 @@ func (t *Type) NumFields() int {
 	if !t.IsStruct() {
 		panic("NumFields can only be called with Struct's")
 	}
-	s := t.Type.(*types.Struct)
-	return s.NumFields()
+	return t.Struct().NumFields()
 }
 
 // FieldTypes returns the type of ith field of the struct and panics on error
@@ -212,10 +245,14 @@ func (t *Type) FieldOff(i int) int64 {
 		if s.NumFields() <= i {
 			panic("Invalid field #")
 		}
+		// the offset depends on the preceding fields
 		std := StdSizes()
-		field := s.Field(int(i))
-		offsets := std.Offsetsof([]*types.Var{field})
-		return offsets[0]
+		fields := make([]*types.Var, s.NumFields())
+		for j := range fields {
+			fields[j] = s.Field(j)
+		}
+		offsets := std.Offsetsof(fields)
+		return offsets[i]
 	}
 }
 
@@ -239,7 +276,7 @@ func (t *Type) SimpleString() string {
 
 func (t *Type) Equal(v ssa.Type) bool {
 	if v2, ok := v.(*Type); ok {
-		return types.Identical(t, v2)
+		return types.Identical(t.Type, v2.Type)
 	}
 	return false
 }
@@ -271,14 +308,16 @@ func (t *Type) Compare(t2 ssa.Type) ssa.Cmp {
 
 // given []T or *T or [n]T, return T
 func (t *Type) ElemType() ssa.Type {
-	// TODO
-	return nil
+	return t.Elem()
 }
 
 // name of ith field of the struct
 func (t *Type) FieldName(i int) string {
-	// TODO
-	return "<ssair.Type.FieldName>"
+	if s := t.Struct(); s == nil {
+		panic("FieldName can only be called with Struct's")
+	} else {
+		return s.Field(i).Name()
+	}
 }
 
 